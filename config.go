@@ -0,0 +1,127 @@
+package ut4updater
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultKeepVersions is the number of versions to keep when not configured
+	DefaultKeepVersions = 2
+	// DefaultUpdateURL is the update server used when not configured
+	DefaultUpdateURL = "https://ut4.donovansolms.com"
+)
+
+// Config holds the updater configuration shared by the launchers
+type Config struct {
+	InstallPath string           `yaml:"InstallPath" json:"InstallPath"`
+	Versioning  VersioningConfig `yaml:"Versioning" json:"Versioning"`
+	SendStats   bool             `yaml:"SendStats" json:"SendStats"`
+	UpdateURL   string           `yaml:"UpdateURL,omitempty" json:"UpdateURL,omitempty"`
+	// PublicKey is the base64 encoded ed25519 key the update server
+	// responses are signed with
+	PublicKey string `yaml:"PublicKey,omitempty" json:"PublicKey,omitempty"`
+}
+
+// VersioningConfig holds the configuration for installed versions
+type VersioningConfig struct {
+	// Keep is the number of versions to keep, 0 updates in place
+	Keep uint `yaml:"Keep" json:"Keep"`
+	// Run is the version to run, either latest, a build or semver
+	Run string `yaml:"Run" json:"Run"`
+	// Channel is the release channel to update from
+	Channel string `yaml:"Channel" json:"Channel"`
+}
+
+// DefaultConfig returns the configuration defaults as documented
+func DefaultConfig() Config {
+	return Config{
+		Versioning: VersioningConfig{
+			Keep:    DefaultKeepVersions,
+			Run:     runVersionLatest,
+			Channel: ChannelStable,
+		},
+		SendStats: true,
+		UpdateURL: DefaultUpdateURL,
+	}
+}
+
+// LoadConfig reads the configuration from the given path. Files with a
+// .json extension are parsed as JSON, everything else as YAML. Options not
+// in the file are set to their defaults
+func LoadConfig(path string) (Config, error) {
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := DefaultConfig()
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(configBytes, &config)
+	} else {
+		err = yaml.Unmarshal(configBytes, &config)
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+// Validate checks that the configuration is usable
+func (config Config) Validate() error {
+	if strings.TrimSpace(config.InstallPath) == "" {
+		return newError(ErrInvalidConfig, errors.New("InstallPath must be specified"))
+	}
+	if strings.TrimSpace(config.Versioning.Run) == "" {
+		return newError(ErrInvalidConfig,
+			errors.New("Versioning.Run must be 'latest' or a version"))
+	}
+	if strings.TrimSpace(config.Versioning.Channel) == "" {
+		return newError(ErrInvalidConfig,
+			errors.New("Versioning.Channel must not be blank"))
+	}
+	if strings.TrimSpace(config.UpdateURL) == "" {
+		return newError(ErrInvalidConfig, errors.New("UpdateURL must not be blank"))
+	}
+	if config.PublicKey != "" {
+		publicKey, err := base64.StdEncoding.DecodeString(config.PublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return newError(ErrInvalidConfig,
+				errors.New("PublicKey must be a base64 encoded ed25519 public key"))
+		}
+	}
+	return nil
+}
+
+// NewFromConfig creates a new instance of UT4Updater from the configuration,
+// the install path is created if it doesn't exist
+func NewFromConfig(config Config) (*UT4Updater, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(config.InstallPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	options := []Option{WithChannel(config.Versioning.Channel)}
+	if config.PublicKey != "" {
+		// Validate made sure the key decodes
+		publicKey, _ := base64.StdEncoding.DecodeString(config.PublicKey)
+		options = append(options, WithPublicKey(publicKey))
+	}
+	return New(config.InstallPath,
+		config.Versioning.Keep,
+		config.Versioning.Run,
+		config.SendStats,
+		strings.TrimRight(config.UpdateURL, "/"),
+		options...)
+}
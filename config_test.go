@@ -0,0 +1,73 @@
+package ut4updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig("./test-resources/config/test.yaml")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if config.InstallPath != "/tmp/somepath" {
+		t.Errorf("Returned InstallPath '%s'. Expected '%s'",
+			config.InstallPath,
+			"/tmp/somepath")
+	}
+	if config.Versioning.Keep != 2 {
+		t.Errorf("Returned Keep '%d'. Expected '%d'", config.Versioning.Keep, 2)
+	}
+	if config.Versioning.Run != "latest" {
+		t.Errorf("Returned Run '%s'. Expected '%s'", config.Versioning.Run, "latest")
+	}
+	if config.SendStats == false {
+		t.Error("SendStats must be true")
+	}
+	if config.UpdateURL != DefaultUpdateURL {
+		t.Errorf("UpdateURL must default to '%s'", DefaultUpdateURL)
+	}
+	if config.Versioning.Channel != ChannelStable {
+		t.Errorf("Channel must default to '%s'", ChannelStable)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tempDir)
+
+	configPath := filepath.Join(tempDir, "config.json")
+	err = ioutil.WriteFile(configPath,
+		[]byte(`{"InstallPath": "/tmp/somepath", "Versioning": {"Keep": 0}, "SendStats": false}`),
+		0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if config.Versioning.Keep != 0 {
+		t.Errorf("Returned Keep '%d'. Expected '%d'", config.Versioning.Keep, 0)
+	}
+	if config.Versioning.Run != "latest" {
+		t.Errorf("Run must default to 'latest', got '%s'", config.Versioning.Run)
+	}
+	if config.SendStats {
+		t.Error("SendStats must be false")
+	}
+
+	err = ioutil.WriteFile(configPath, []byte(`{"SendStats": false}`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = LoadConfig(configPath)
+	if err == nil {
+		t.Error("A config without an InstallPath must fail")
+	}
+}
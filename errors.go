@@ -0,0 +1,150 @@
+package ut4updater
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The errors returned by the updater, use errors.Is to check for them since
+// they are usually wrapped with the underlying cause
+var (
+	// ErrNoInstalledVersion is returned when no version is installed
+	ErrNoInstalledVersion = errors.New("No Unreal Tournament versions installed")
+	// ErrVersionNotInstalled is returned when the requested version
+	// is not installed
+	ErrVersionNotInstalled = errors.New("The version is not installed")
+	// ErrVersionExists is returned when a version that must not exist
+	// is already installed
+	ErrVersionExists = errors.New("The version already exists")
+	// ErrInvalidInstallPath is returned when the install path can't be used
+	ErrInvalidInstallPath = errors.New("The install path must be a directory")
+	// ErrInvalidConfig is returned when the configuration is not valid
+	ErrInvalidConfig = errors.New("Invalid configuration")
+	// ErrServerUnavailable is returned when the update server can't be
+	// reached or returns a server error
+	ErrServerUnavailable = errors.New("The update server is unavailable")
+	// ErrNotFound is returned when the update server doesn't have the
+	// requested version, hashes or package
+	ErrNotFound = errors.New("The update server doesn't have the requested resource")
+	// ErrInvalidUpdateCommand is returned when the update server returns
+	// an update command without a valid URL, size or SHA256
+	ErrInvalidUpdateCommand = errors.New("Invalid update command received")
+	// ErrChecksumMismatch is returned when a downloaded package doesn't match
+	// the size or SHA256 given by the update server
+	ErrChecksumMismatch = errors.New("The downloaded package doesn't match the expected checksum")
+	// ErrInvalidSignature is returned when a response from the update server or
+	// the cached version map doesn't match its signature
+	ErrInvalidSignature = errors.New("The signature doesn't match the public key")
+	// ErrDownloadTimeout is returned when a package download takes longer than
+	// the download timeout or stops receiving data
+	ErrDownloadTimeout = errors.New("The download timed out")
+	// ErrInvalidPackage is returned when an update package can't be read or
+	// contains unsafe entries
+	ErrInvalidPackage = errors.New("Invalid update package")
+	// ErrRollbackFailed is returned when a failed update could not be
+	// rolled back
+	ErrRollbackFailed = errors.New("Unable to roll back the failed update")
+	// ErrRepairFailed is returned when an installation still has missing or
+	// modified files after it was repaired
+	ErrRepairFailed = errors.New("Unable to repair the installation")
+	// ErrVersionYanked is returned when the version map marks the version
+	// to install as yanked
+	ErrVersionYanked = errors.New("The version has been yanked")
+	// ErrUpdaterOutdated is returned when the version to install requires
+	// a newer updater
+	ErrUpdaterOutdated = errors.New("A newer updater is required")
+)
+
+// updaterError attaches one of the updater errors to the underlying cause,
+// errors.Is matches both
+type updaterError struct {
+	kind  error
+	cause error
+}
+
+// newError returns the cause wrapped with the updater error kind
+func newError(kind error, cause error) error {
+	return &updaterError{kind: kind, cause: cause}
+}
+
+func (err *updaterError) Error() string {
+	return fmt.Sprintf("%s: %s", err.kind.Error(), err.cause.Error())
+}
+
+// Unwrap returns the underlying cause
+func (err *updaterError) Unwrap() error {
+	return err.cause
+}
+
+// Is returns true if target is the updater error kind
+func (err *updaterError) Is(target error) bool {
+	return target == err.kind
+}
+
+// UnsafeEntryError is returned when an update package contains an entry
+// that would be written outside the install path or can't be
+// extracted safely. It matches ErrInvalidPackage
+type UnsafeEntryError struct {
+	Name   string
+	Reason string
+}
+
+func (err *UnsafeEntryError) Error() string {
+	return fmt.Sprintf("Unsafe package entry '%s': %s", err.Name, err.Reason)
+}
+
+// Is returns true for ErrInvalidPackage
+func (err *UnsafeEntryError) Is(target error) bool {
+	return target == ErrInvalidPackage
+}
+
+// HashError is returned when files could not be hashed, Files holds
+// the error for every file that failed
+type HashError struct {
+	Files map[string]string
+}
+
+func (err *HashError) Error() string {
+	paths := make([]string, 0, len(err.Files))
+	for path := range err.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	failures := make([]string, len(paths))
+	for i, path := range paths {
+		failures[i] = fmt.Sprintf("'%s': %s", path, err.Files[path])
+	}
+	return fmt.Sprintf("Unable to hash %d files: %s",
+		len(paths),
+		strings.Join(failures, ", "))
+}
+
+// HTTPStatusError is returned when the update server responds with a
+// non 2XX status code. Server errors match ErrServerUnavailable and
+// 404 matches ErrNotFound
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (err *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Received non 2XX status code from '%s': %s",
+		err.URL,
+		err.Status)
+}
+
+// Is returns true for the updater error matching the status code
+func (err *HTTPStatusError) Is(target error) bool {
+	switch target {
+	case ErrServerUnavailable:
+		return err.StatusCode >= 500 ||
+			err.StatusCode == http.StatusTooManyRequests
+	case ErrNotFound:
+		return err.StatusCode == http.StatusNotFound
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package ut4updater
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+
+	_, err := updater.GetVersionPath("003", true)
+	if !errors.Is(err, ErrVersionExists) {
+		t.Errorf("Expected ErrVersionExists, got '%v'", err)
+	}
+
+	updater.runVersion = "999"
+	_, err = updater.GetRunVersion()
+	if !errors.Is(err, ErrVersionNotInstalled) {
+		t.Errorf("Expected ErrVersionNotInstalled, got '%v'", err)
+	}
+
+	_, err = updater.getRemoteVersionHashes(context.Background(), "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got '%v'", err)
+	}
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("Expected an HTTPStatusError, got '%v'", err)
+	}
+
+	packagePath := filepath.Join(installPath, "invalid.tar.gz")
+	err = ioutil.WriteFile(packagePath, []byte("not a package"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = updater.applyUpdate(context.Background(), packagePath, filepath.Join(installPath, "003"), nil, nil)
+	if !errors.Is(err, ErrInvalidPackage) {
+		t.Errorf("Expected ErrInvalidPackage, got '%v'", err)
+	}
+
+	err = Config{}.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got '%v'", err)
+	}
+}
+
+func TestErrNoInstalledVersion(t *testing.T) {
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(installPath)
+	previousPath := updater.installPath
+	defer func() { updater.installPath = previousPath }()
+	updater.installPath = installPath
+
+	_, err = updater.GetLatestVersion()
+	if !errors.Is(err, ErrNoInstalledVersion) {
+		t.Errorf("Expected ErrNoInstalledVersion, got '%v'", err)
+	}
+}
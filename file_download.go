@@ -0,0 +1,294 @@
+package ut4updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// maxFileDownloads is the number of files downloaded at the same time when
+// the update server has no package for an update
+const maxFileDownloads = 4
+
+// fileDownloadJob downloads a file from the content addressed file store
+// of the update server and verifies its hash
+type fileDownloadJob struct {
+	ctx      context.Context
+	updater  *UT4Updater
+	hash     string
+	savePath string
+	results  chan fileDownloadResult
+}
+
+// fileDownloadResult is the outcome of a fileDownloadJob
+type fileDownloadResult struct {
+	hash string
+	err  error
+}
+
+// Process is an implementation of Job.Process()
+func (job fileDownloadJob) Process() {
+	// Jobs still queued when the context is cancelled are skipped
+	if job.ctx.Err() != nil {
+		job.results <- fileDownloadResult{hash: job.hash, err: job.ctx.Err()}
+		return
+	}
+	url := fmt.Sprintf("%s/%s/%s",
+		job.updater.updateURL,
+		"update/ut4-file",
+		job.hash)
+	// The size of the file isn't known, it's checked by its hash only
+	_, err := job.updater.downloadUpdate(
+		job.ctx,
+		UpdateCommand{UpdateURL: url, SHA256: job.hash},
+		job.savePath,
+		nil)
+	job.results <- fileDownloadResult{hash: job.hash, err: err}
+}
+
+// getRemoteVersionModes retrieves the permissions of the files of a version
+// from the update server. Files that aren't listed have mode 0644, an
+// ErrNotFound is returned if the update server doesn't list the permissions
+func (updater *UT4Updater) getRemoteVersionModes(
+	ctx context.Context,
+	version string) (map[string]os.FileMode, error) {
+
+	body, _, err := updater.getSigned(ctx,
+		fmt.Sprintf("%s/%s", "update/ut4-mode", version))
+	if err != nil {
+		return nil, err
+	}
+
+	var versionModes map[string]string
+	err = json.Unmarshal(body, &versionModes)
+	if err != nil {
+		return nil, err
+	}
+	modes := make(map[string]os.FileMode, len(versionModes))
+	for file, mode := range versionModes {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0777 {
+			return nil, newError(ErrInvalidUpdateCommand,
+				fmt.Errorf("Invalid mode '%s' for '%s'", mode, file))
+		}
+		modes[file] = os.FileMode(perm)
+	}
+	return modes, nil
+}
+
+// fileModesKnown returns whether the permissions of every file added in
+// deltaOperations are known. The file store only holds contents, without
+// the modes from the update server added files can't be created
+func fileModesKnown(
+	deltaOperations map[string]string,
+	modes map[string]os.FileMode) bool {
+	if modes != nil {
+		return true
+	}
+	for _, operation := range deltaOperations {
+		if operation == operationAdded {
+			return false
+		}
+	}
+	return true
+}
+
+// fileMode returns the permissions to write file to target with. Without
+// the modes from the update server an existing file keeps its permissions
+func fileMode(
+	file string,
+	target string,
+	modes map[string]os.FileMode) (os.FileMode, error) {
+	if modes != nil {
+		if mode, ok := modes[file]; ok {
+			return mode, nil
+		}
+		return 0644, nil
+	}
+	fileInfo, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return 0, newError(ErrNotFound,
+			fmt.Errorf("The mode of '%s' is unknown", file))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Mode().Perm(), nil
+}
+
+// downloadFiles downloads the files added and modified in deltaOperations
+// to filesPath, each file is saved under its SHA256 hash in hashes. Files
+// that were downloaded before are reused, progress is reported to feedback
+func (updater *UT4Updater) downloadFiles(
+	ctx context.Context,
+	filesPath string,
+	deltaOperations map[string]string,
+	hashes map[string]string,
+	nextVersion string,
+	feedback chan []byte) error {
+
+	// Files with the same contents are only downloaded once
+	wanted := make(map[string]string)
+	for file, operation := range deltaOperations {
+		if operation == operationRemoved {
+			continue
+		}
+		hash := hashes[file]
+		if _, err := hex.DecodeString(hash); err != nil ||
+			len(hash) != sha256.Size*2 {
+			return newError(ErrInvalidUpdateCommand,
+				fmt.Errorf("Invalid SHA256 '%s' for '%s'", hash, file))
+		}
+		wanted[hash] = file
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+	err := os.MkdirAll(filesPath, 0755)
+	if err != nil {
+		return err
+	}
+
+	// The results are buffered so the workers never wait on the feedback
+	results := make(chan fileDownloadResult, len(wanted))
+	jobs := make([]job, 0, len(wanted))
+	for hash := range wanted {
+		jobs = append(jobs, fileDownloadJob{
+			ctx:      ctx,
+			updater:  updater,
+			hash:     hash,
+			savePath: filepath.Join(filesPath, hash),
+			results:  results,
+		})
+	}
+	workers := runJobs(maxFileDownloads, jobs)
+
+	failed := make(map[string]error)
+	for finished := 1; finished <= len(wanted); finished++ {
+		result := <-results
+		if result.err != nil {
+			failed[result.hash] = result.err
+			continue
+		}
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:  UpdateStatusDownloading,
+			Version: nextVersion,
+			Message: wanted[result.hash],
+			Percent: float64(finished) / float64(len(wanted)) * 100.00,
+		})
+	}
+	// Block until all the workers exit
+	workers.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(failed) > 0 {
+		failedHashes := make([]string, 0, len(failed))
+		for hash := range failed {
+			failedHashes = append(failedHashes, hash)
+		}
+		sort.Strings(failedHashes)
+		hash := failedHashes[0]
+		return fmt.Errorf("Unable to download %d files, '%s': %w",
+			len(failed),
+			wanted[hash],
+			failed[hash])
+	}
+	return nil
+}
+
+// applyFiles applies the files downloaded by downloadFiles to installPath
+// with the permissions in modes while reporting every file operation to
+// feedback
+func (updater *UT4Updater) applyFiles(
+	ctx context.Context,
+	filesPath string,
+	installPath string,
+	deltaOperations map[string]string,
+	hashes map[string]string,
+	modes map[string]os.FileMode,
+	nextVersion string,
+	feedback chan []byte) error {
+
+	applyFeedbackChan := make(chan ApplyProgressEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range applyFeedbackChan {
+			updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+				Status:  UpdateStatusApplying,
+				Version: nextVersion,
+				Message: fmt.Sprintf("%s %s", event.Operation, event.Filepath),
+			})
+		}
+	}()
+	err := updater.applyStaged(
+		ctx,
+		installPath,
+		deltaOperations,
+		applyFeedbackChan,
+		func(stagingPath string) error {
+			return updater.copyDownloadedFiles(
+				ctx,
+				filesPath,
+				stagingPath,
+				deltaOperations,
+				hashes,
+				modes,
+				applyFeedbackChan)
+		})
+	close(applyFeedbackChan)
+	<-done
+	return err
+}
+
+// copyDownloadedFiles writes the files added and modified in deltaOperations
+// from filesPath to installPath with the permissions from fileMode
+func (updater *UT4Updater) copyDownloadedFiles(
+	ctx context.Context,
+	filesPath string,
+	installPath string,
+	deltaOperations map[string]string,
+	hashes map[string]string,
+	modes map[string]os.FileMode,
+	feedbackChan chan ApplyProgressEvent) error {
+
+	for file, operation := range deltaOperations {
+		if operation == operationRemoved {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		target, err := safeJoin(installPath, file)
+		if err != nil {
+			return err
+		}
+		mode, err := fileMode(file, target, modes)
+		if err != nil {
+			return err
+		}
+		source, err := os.Open(filepath.Join(filesPath, hashes[file]))
+		if err != nil {
+			return err
+		}
+		err = extractFile(source, target, mode)
+		source.Close()
+		if err != nil {
+			return err
+		}
+		if feedbackChan != nil {
+			feedbackChan <- ApplyProgressEvent{
+				Filepath:  file,
+				Operation: operation,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package ut4updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUpdateFiles(t *testing.T) {
+	for _, keep := range []uint{0, 2} {
+		plannerUpdater, server, cleanup := newPlannerTestUpdater(t, keep)
+		nextHash := plannerTestHashes("006")["UT4.txt"]
+		server.addFile(nextHash, "This is version 006")
+
+		newVersion, err := plannerUpdater.Update(nil)
+		if err != nil {
+			cleanup()
+			t.Fatal(err.Error())
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(newVersion.Path, "UT4.txt"))
+		if err != nil {
+			cleanup()
+			t.Fatal(err.Error())
+		}
+		if string(contents) != "This is version 006" {
+			t.Errorf("The files were not applied, UT4.txt contains '%s'", contents)
+		}
+		_, err = os.Stat(filepath.Join(newVersion.Path, "Extra.txt"))
+		if !os.IsNotExist(err) {
+			t.Error("Extra.txt should have been removed by the update")
+		}
+		if server.fileRequests[nextHash] != 1 {
+			t.Errorf("Expected UT4.txt to be downloaded once, got %d",
+				server.fileRequests[nextHash])
+		}
+		// The downloaded files are removed once applied
+		cached, _ := ioutil.ReadDir(
+			filepath.Join(plannerUpdater.installPath, packageCacheDir))
+		if len(cached) != 0 {
+			t.Errorf("Expected an empty package cache, got %d entries", len(cached))
+		}
+		cleanup()
+	}
+}
+
+func TestUpdateFilesFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		err      error
+	}{
+		{name: "checksum", contents: "This is not version 006", err: ErrChecksumMismatch},
+		{name: "missing", err: ErrNotFound},
+	}
+	for _, test := range tests {
+		plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+		if test.contents != "" {
+			server.addFile(plannerTestHashes("006")["UT4.txt"], test.contents)
+		}
+		_, err := plannerUpdater.Update(nil)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: Expected '%v', got '%v'", test.name, test.err, err)
+		}
+		_, err = os.Stat(filepath.Join(plannerUpdater.installPath, "006"))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s: Version 006 must not be installed", test.name)
+		}
+		contents, err := ioutil.ReadFile(
+			filepath.Join(plannerUpdater.installPath, "003", "UT4.txt"))
+		if err != nil || string(contents) != "This is version 003" {
+			t.Errorf("%s: Version 003 must not be changed", test.name)
+		}
+		cleanup()
+	}
+}
+
+func TestDownloadFiles(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	hashes := plannerTestHashes("004")
+	hashes["Copy.txt"] = hashes["UT4.txt"]
+	server.addFile(hashes["UT4.txt"], "This is version 004")
+	deltaOperations := map[string]string{
+		"UT4.txt":   operationModified,
+		"Copy.txt":  operationAdded,
+		"Extra.txt": operationRemoved,
+	}
+	filesPath := plannerUpdater.getFileCachePath("test")
+
+	// Files with the same contents and files downloaded before are
+	// only requested once
+	for i := 0; i < 2; i++ {
+		err := plannerUpdater.downloadFiles(
+			context.Background(),
+			filesPath,
+			deltaOperations,
+			hashes,
+			"004",
+			nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if server.fileRequests[hashes["UT4.txt"]] != 1 {
+		t.Errorf("Expected 1 request, got %d", server.fileRequests[hashes["UT4.txt"]])
+	}
+
+	installPath := filepath.Join(plannerUpdater.installPath, "003")
+	// Added files can't be created without their modes
+	err := plannerUpdater.applyFiles(
+		context.Background(),
+		filesPath,
+		installPath,
+		deltaOperations,
+		hashes,
+		nil,
+		"004",
+		nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without modes, got '%v'", err)
+	}
+	_, err = os.Stat(filepath.Join(installPath, "Copy.txt"))
+	if !os.IsNotExist(err) {
+		t.Error("Copy.txt must not be created without its mode")
+	}
+
+	err = plannerUpdater.applyFiles(
+		context.Background(),
+		filesPath,
+		installPath,
+		deltaOperations,
+		hashes,
+		map[string]os.FileMode{"Copy.txt": 0755},
+		"004",
+		nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	modes := map[string]os.FileMode{"UT4.txt": 0644, "Copy.txt": 0755}
+	for file, mode := range modes {
+		contents, err := ioutil.ReadFile(filepath.Join(installPath, file))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(contents) != "This is version 004" {
+			t.Errorf("Expected %s to be updated, got '%s'", file, contents)
+		}
+		fileInfo, err := os.Stat(filepath.Join(installPath, file))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if fileInfo.Mode().Perm() != mode {
+			t.Errorf("Expected %s to have mode %v, got %v",
+				file, mode, fileInfo.Mode().Perm())
+		}
+	}
+	_, err = os.Stat(filepath.Join(installPath, "Extra.txt"))
+	if !os.IsNotExist(err) {
+		t.Error("Extra.txt should have been removed")
+	}
+
+	// Hashes are used as file names and must be valid
+	hashes["Copy.txt"] = "../../escape"
+	err = plannerUpdater.downloadFiles(
+		context.Background(),
+		filesPath,
+		deltaOperations,
+		hashes,
+		"004",
+		nil)
+	if !errors.Is(err, ErrInvalidUpdateCommand) {
+		t.Errorf("Expected ErrInvalidUpdateCommand, got '%v'", err)
+	}
+}
+
+func TestDownloadFilesWorkersExit(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	hashes := plannerTestHashes("004")
+	server.addFile(hashes["UT4.txt"], "This is version 004")
+	deltaOperations := map[string]string{"UT4.txt": operationModified}
+
+	plannerUpdater.httpClient.CloseIdleConnections()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		err := plannerUpdater.downloadFiles(
+			context.Background(),
+			plannerUpdater.getFileCachePath(fmt.Sprintf("test%d", i)),
+			deltaOperations,
+			hashes,
+			"004",
+			nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if left := goroutinesAbove(plannerUpdater, before); left > 0 {
+		t.Errorf("Expected the download workers to exit, %d goroutines left running",
+			left)
+	}
+}
+
+func TestGetRemoteVersionModes(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	ctx := context.Background()
+
+	_, err := plannerUpdater.getRemoteVersionModes(ctx, "004")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without modes, got '%v'", err)
+	}
+	server.addModes("004", map[string]string{"Run.sh": "0755"})
+	modes, err := plannerUpdater.getRemoteVersionModes(ctx, "004")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(modes) != 1 || modes["Run.sh"] != 0755 {
+		t.Errorf("Expected Run.sh with mode 0755, got %v", modes)
+	}
+	for _, mode := range []string{"rwxr-xr-x", "1755", "0855"} {
+		server.addModes("004", map[string]string{"Run.sh": mode})
+		_, err = plannerUpdater.getRemoteVersionModes(ctx, "004")
+		if !errors.Is(err, ErrInvalidUpdateCommand) {
+			t.Errorf("%s: Expected ErrInvalidUpdateCommand, got '%v'", mode, err)
+		}
+	}
+}
+
+func TestRepairFiles(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	target := filepath.Join(plannerUpdater.installPath, "003", "UT4.txt")
+	err := os.Remove(target)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server.addFile(plannerTestHashes("003")["UT4.txt"], "This is version 003")
+
+	// Without the modes the missing file isn't restored as 0644
+	_, err = plannerUpdater.Repair("003", nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without modes, got '%v'", err)
+	}
+	_, err = os.Stat(target)
+	if !os.IsNotExist(err) {
+		t.Error("UT4.txt must not be restored without its mode")
+	}
+
+	server.addModes("003", map[string]string{"UT4.txt": "0755"})
+	result, err := plannerUpdater.Repair("003", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !result.IsValid() {
+		t.Errorf("Expected a valid install after the repair, got %+v", result)
+	}
+	fileInfo, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fileInfo.Mode().Perm() != 0755 {
+		t.Errorf("Expected UT4.txt to be restored with mode 0755, got %v",
+			fileInfo.Mode().Perm())
+	}
+}
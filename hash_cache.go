@@ -0,0 +1,142 @@
+package ut4updater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// metadataDir is the directory in every version directory where the
+// updater keeps its own files, it's excluded from the file list
+const metadataDir = ".ut4updater"
+
+// hashCacheFile is the hash cache in the metadata directory
+const hashCacheFile = "hashes.json"
+
+// hashCacheVersion is increased when the hash cache format changes,
+// caches of other versions are discarded
+const hashCacheVersion = 1
+
+// hashCacheEntry is the hash of a file with the size and modification
+// time the file had when it was hashed
+type hashCacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	SHA256  string `json:"sha256"`
+}
+
+// hashCache holds the hashes of the files of a version keyed by their
+// path relative to the version directory
+type hashCache struct {
+	Version int                       `json:"version"`
+	Files   map[string]hashCacheEntry `json:"files"`
+}
+
+// newHashCache returns an empty hash cache
+func newHashCache() hashCache {
+	return hashCache{
+		Version: hashCacheVersion,
+		Files:   make(map[string]hashCacheEntry),
+	}
+}
+
+// loadHashCache reads the hash cache of installPath, an empty cache is
+// returned if it doesn't exist or can't be read
+func loadHashCache(installPath string) hashCache {
+	data, err := ioutil.ReadFile(
+		filepath.Join(installPath, metadataDir, hashCacheFile))
+	if err != nil {
+		return newHashCache()
+	}
+	var cache hashCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil || cache.Version != hashCacheVersion || cache.Files == nil {
+		return newHashCache()
+	}
+	return cache
+}
+
+// lookup returns the cached hash for relativePath if the file still has
+// the size and modification time it had when it was hashed
+func (cache hashCache) lookup(
+	relativePath string,
+	fileInfo os.FileInfo) (string, bool) {
+	entry, ok := cache.Files[relativePath]
+	if !ok ||
+		entry.Size != fileInfo.Size() ||
+		entry.ModTime != fileInfo.ModTime().UnixNano() {
+		return "", false
+	}
+	return entry.SHA256, true
+}
+
+// add stores the hash for relativePath with the current size and
+// modification time of the file
+func (cache hashCache) add(
+	relativePath string,
+	fileInfo os.FileInfo,
+	hash string) {
+	cache.Files[relativePath] = hashCacheEntry{
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime().UnixNano(),
+		SHA256:  hash,
+	}
+}
+
+// save writes the hash cache to installPath
+func (cache hashCache) save(installPath string) error {
+	return writeMetadataFile(installPath, hashCacheFile, cache)
+}
+
+// seedHashCache stores the known hashes of the files in installPath, this
+// is used after an update when the hashes of the new version are known
+func seedHashCache(installPath string, hashes map[string]string) error {
+	cache := newHashCache()
+	for relativePath, hash := range hashes {
+		fileInfo, err := os.Stat(
+			filepath.Join(installPath, filepath.FromSlash(relativePath)))
+		if err != nil {
+			// Files that can't be found are hashed on the next update
+			continue
+		}
+		cache.add(relativePath, fileInfo, hash)
+	}
+	return cache.save(installPath)
+}
+
+// writeMetadataFile writes value as JSON to name in the metadata directory
+// of installPath. The file is replaced atomically so an interrupted write
+// doesn't leave a corrupt file, and files hard linked into a staged update
+// aren't modified
+func writeMetadataFile(
+	installPath string,
+	name string,
+	value interface{}) error {
+	metadataPath := filepath.Join(installPath, metadataDir)
+	err := os.MkdirAll(metadataPath, 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(metadataPath, name)
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	err = os.Rename(tempFile.Name(), filepath.Join(metadataPath, name))
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package ut4updater
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashInstallCache(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+
+	hashes, err := updater.hashInstall(context.Background(), versionPath, false, "004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	realHash := hashes["UT4.txt"]
+	if realHash == "" {
+		t.Fatal("UT4.txt must be hashed")
+	}
+
+	// The cached hash is used while the file is unchanged
+	cache := loadHashCache(versionPath)
+	entry, ok := cache.Files["UT4.txt"]
+	if !ok {
+		t.Fatal("UT4.txt must be in the hash cache")
+	}
+	entry.SHA256 = "cached"
+	cache.Files["UT4.txt"] = entry
+	err = cache.save(versionPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	hashes, err = updater.hashInstall(context.Background(), versionPath, false, "004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if hashes["UT4.txt"] != "cached" {
+		t.Errorf("Expected the cached hash, got '%s'", hashes["UT4.txt"])
+	}
+	if _, ok := hashes[metadataDir+"/"+hashCacheFile]; ok {
+		t.Error("The hash cache must not be hashed")
+	}
+
+	// Forcing a rehash ignores the cache
+	hashes, err = updater.hashInstall(context.Background(), versionPath, true, "004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if hashes["UT4.txt"] != realHash {
+		t.Errorf("Expected hash '%s' after a forced rehash, got '%s'", realHash, hashes["UT4.txt"])
+	}
+}
+
+func TestHashInstallCacheInvalidation(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+
+	_, err := updater.hashInstall(context.Background(), versionPath, false, "004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(versionPath, "UT4.txt"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	hashes, err := updater.hashInstall(context.Background(), versionPath, false, "004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	actual, err := hashFile(filepath.Join(versionPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if hashes["UT4.txt"] != actual {
+		t.Errorf("Expected hash '%s' for the changed file, got '%s'", actual, hashes["UT4.txt"])
+	}
+}
+
+func TestLoadHashCacheCorrupt(t *testing.T) {
+	versionPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(versionPath)
+	err = os.MkdirAll(filepath.Join(versionPath, metadataDir), 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(
+		filepath.Join(versionPath, metadataDir, hashCacheFile),
+		[]byte("{not json"),
+		0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cache := loadHashCache(versionPath)
+	if len(cache.Files) != 0 {
+		t.Errorf("A corrupt cache must be discarded, got %d files", len(cache.Files))
+	}
+}
@@ -1,27 +1,44 @@
 package ut4updater
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"sync"
 	"time"
 )
 
 // HashJob is the structure that will be passed for processing of hashes
 type HashJob struct {
+	ctx      context.Context
 	filepath string
 	progress chan HashProgressEvent
 }
 
+// hashReportInterval is how often hashing progress is reported
+const hashReportInterval = time.Second
+
 // Process is an implementation of Job.Process()
 func (job HashJob) Process() {
 	filename := filepath.Base(job.filepath)
+	// Jobs created without a context are never cancelled
+	ctx := job.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// Jobs still queued when the context is cancelled are skipped
+	if ctx.Err() != nil {
+		job.progress <- HashProgressEvent{
+			Filename: filename,
+			Filepath: job.filepath,
+			Error:    ctx.Err().Error(),
+		}
+		return
+	}
 	fileInfo, err := os.Stat(job.filepath)
 	if err != nil {
-
 		job.progress <- HashProgressEvent{
 			Filename: filename,
 			Filepath: job.filepath,
@@ -29,81 +46,65 @@ func (job HashJob) Process() {
 		}
 		return
 	}
-	// HACK / TODO: This returns a zero byte hash since copy doesn't report to the
-	// channel because the is nothing to write. I need to do something proper
-	// here
-	if fileInfo.Size() == 0 {
+	file, err := os.Open(job.filepath)
+	if err != nil {
 		job.progress <- HashProgressEvent{
-			Filename:  filename,
-			Filepath:  job.filepath,
-			Completed: true,
-			Hash:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			Filename: filename,
+			Filepath: job.filepath,
+			Error:    err.Error(),
 		}
 		return
 	}
-	file, err := os.Open(job.filepath)
-	if err != nil {
-		if err != nil {
+	defer file.Close()
+
+	// Start the hashing in the background since large files (.pak) files
+	// could take quite some time to complete
+	tracker := NewProgressTracker(fileInfo.Size())
+	hasher := sha256.New()
+	// The tracker completes on EOF before the last read is hashed, so
+	// wait for the copy itself
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(hasher, tracker.Reader(file))
+		copied <- err
+	}()
+
+	ticker := time.NewTicker(hashReportInterval)
+	defer ticker.Stop()
+HashLoop:
+	for {
+		select {
+		case <-ticker.C:
+			mbps, eta, percent := progressStats(tracker)
+			job.progress <- HashProgressEvent{
+				Filename: filename,
+				Filepath: job.filepath,
+				Mbps:     mbps,
+				ETA:      eta,
+				Percent:  percent,
+			}
+		case <-ctx.Done():
+			// Closing the file stops the copy
+			file.Close()
+			<-copied
 			job.progress <- HashProgressEvent{
 				Filename: filename,
 				Filepath: job.filepath,
-				Error:    err.Error(),
+				Error:    ctx.Err().Error(),
 			}
-			fmt.Println(err.Error())
 			return
+		case err = <-copied:
+			break HashLoop
 		}
 	}
-	defer file.Close()
-	// Set up an internal hash progress tracker
-	hashProgressChan := make(chan int)
-	hasher := sha256.New()
-	fileReader := io.TeeReader(file, ProgressTracker{progress: hashProgressChan})
-
-	// Start the hashing in the background since large files (.pak) files
-	// could take quite some time to complete
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		_, err := io.Copy(hasher, fileReader)
-		// Send the error back to the progress tracker
-		/*job.progress <- HashProgressEvent{
+	if err != nil {
+		job.progress <- HashProgressEvent{
 			Filename: filename,
 			Filepath: job.filepath,
 			Error:    err.Error(),
-		}*/
-		_ = err
-	}()
-	bytesHashed := 0
-	// Report hashing progress once a second
-	progressReportInterval := time.Second
-	lastProgressReport := time.Now()
-	// Track the bytes per second to determine estimated
-	// time remaining
-	bytesPerSecond := 0
-	bytesLeft := fileInfo.Size()
-	// Only reads the channel is there was something to do
-	if bytesLeft > 0 {
-		for hashedBytes := range hashProgressChan {
-			bytesLeft -= int64(hashedBytes)
-			bytesHashed += hashedBytes
-			bytesPerSecond += hashedBytes
-			// If enough time has passed, report the progress
-			if time.Since(lastProgressReport) > progressReportInterval {
-				job.progress <- HashProgressEvent{
-					Filename: filename,
-					Filepath: job.filepath,
-					Mbps:     float64(bytesPerSecond) / 1024.00 / 1024.00,
-					ETA:      float64(bytesLeft) / float64(bytesPerSecond),
-					Percent:  float64(bytesHashed) / float64(fileInfo.Size()) * 100.00,
-				}
-				// Reset counters
-				lastProgressReport = time.Now()
-				bytesPerSecond = 0
-			}
 		}
+		return
 	}
-	wg.Wait()
 	job.progress <- HashProgressEvent{
 		Filename:  filename,
 		Filepath:  job.filepath,
@@ -4,14 +4,25 @@ package ut4updater
 // https://www.socketloop.com/tutorials/golang-copy-directory-including-sub-directories-files
 // with slight modifications because I am too lazy to build my own
 import (
-	"fmt"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // CopyFile copies afile from source to destination and preserves permissions
 func CopyFile(source string, dest string) (err error) {
+	return copyFile(context.Background(), source, dest, nil)
+}
+
+// copyFile is CopyFile with the bytes copied counted by tracker if not nil,
+// the copy stops when ctx is cancelled
+func copyFile(
+	ctx context.Context,
+	source string,
+	dest string,
+	tracker *ProgressTracker) (err error) {
 	sourcefile, err := os.Open(source)
 	if err != nil {
 		return err
@@ -24,18 +35,38 @@ func CopyFile(source string, dest string) (err error) {
 	}
 	defer destfile.Close()
 
-	_, err = io.Copy(destfile, sourcefile)
-	if err == nil {
-		sourceinfo, err := os.Stat(source)
-		if err != nil {
-			os.Chmod(dest, sourceinfo.Mode())
-		}
+	var writer io.Writer = destfile
+	if tracker != nil {
+		writer = tracker.Writer(destfile)
+	}
+	_, err = io.Copy(writer, contextReader{ctx: ctx, reader: sourcefile})
+	if err != nil {
+		return err
+	}
+	err = destfile.Close()
+	if err != nil {
+		return err
+	}
+	sourceinfo, err := os.Stat(source)
+	if err != nil {
+		return err
 	}
-	return
+	return os.Chmod(dest, sourceinfo.Mode())
 }
 
-// CopyDir copies a directory and all contents while preserving permissions
+// CopyDir copies a directory and all contents while preserving permissions,
+// symbolic links are recreated instead of followed
 func CopyDir(source string, dest string) (err error) {
+	return copyDir(context.Background(), source, dest, nil)
+}
+
+// copyDir is CopyDir with the bytes copied counted by tracker if not nil,
+// the copy stops when ctx is cancelled
+func copyDir(
+	ctx context.Context,
+	source string,
+	dest string,
+	tracker *ProgressTracker) (err error) {
 
 	// get properties of source dir
 	sourceinfo, err := os.Stat(source)
@@ -48,26 +79,134 @@ func CopyDir(source string, dest string) (err error) {
 	if err != nil {
 		return err
 	}
-	directory, _ := os.Open(source)
+	directory, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
 
 	objects, err := directory.Readdir(-1)
+	if err != nil {
+		return err
+	}
 	for _, obj := range objects {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		sourcefilepointer := filepath.Join(source, obj.Name())
 		destinationfilepointer := filepath.Join(dest, obj.Name())
-		if obj.IsDir() {
+		switch {
+		case obj.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(sourcefilepointer)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(link, destinationfilepointer)
+			if err != nil {
+				return err
+			}
+		case obj.IsDir():
 			// create sub-directories - recursively
-			err = CopyDir(sourcefilepointer, destinationfilepointer)
+			err = copyDir(ctx, sourcefilepointer, destinationfilepointer, tracker)
 			if err != nil {
-				fmt.Println(err)
+				return err
 			}
-		} else {
+		default:
 			// perform copy
-			err = CopyFile(sourcefilepointer, destinationfilepointer)
+			err = copyFile(ctx, sourcefilepointer, destinationfilepointer, tracker)
 			if err != nil {
-				fmt.Println(err)
+				return err
 			}
 		}
+	}
+	return nil
+}
+
+// LinkDir recreates the directory tree of source at dest with hard links to
+// the files in source. Files that can't be linked, for instance across
+// filesystems, are copied
+func LinkDir(source string, dest string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, relativePath)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			if os.Link(path, target) == nil {
+				return nil
+			}
+			return CopyFile(path, target)
+		}
+	})
+}
+
+// contextReader reads from reader until ctx is cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
 
+func (reader contextReader) Read(data []byte) (int, error) {
+	if reader.ctx.Err() != nil {
+		return 0, reader.ctx.Err()
+	}
+	return reader.reader.Read(data)
+}
+
+// DirSize returns the total size of the files in path
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// job is a unit of work for runJobs
+type job interface {
+	Process()
+}
+
+// runJobs processes the jobs with at most maxWorkers at the same time. The
+// returned wait group is done once every job has been processed, the
+// workers exit once there are no more jobs
+func runJobs(maxWorkers int, jobs []job) *sync.WaitGroup {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+	queue := make(chan job, len(jobs))
+	for _, queuedJob := range jobs {
+		queue <- queuedJob
+	}
+	close(queue)
+	var workers sync.WaitGroup
+	for i := 0; i < maxWorkers && i < len(jobs); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for queuedJob := range queue {
+				queuedJob.Process()
+			}
+		}()
 	}
-	return
+	return &workers
 }
@@ -0,0 +1,106 @@
+package ut4updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCopyDir(t *testing.T) {
+	source, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(source)
+	dest, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dest)
+
+	err = os.MkdirAll(filepath.Join(source, "Binaries"), 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(
+		filepath.Join(source, "Binaries", "UE4-Linux-Shipping"),
+		[]byte("binary"),
+		0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = os.Symlink(
+		filepath.Join("Binaries", "UE4-Linux-Shipping"),
+		filepath.Join(source, "UT4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = CopyDir(source, filepath.Join(dest, "copy"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fileInfo, err := os.Stat(
+		filepath.Join(dest, "copy", "Binaries", "UE4-Linux-Shipping"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fileInfo.Mode().Perm() != 0755 {
+		t.Errorf("Expected mode 0755, got %v", fileInfo.Mode().Perm())
+	}
+	link, err := os.Readlink(filepath.Join(dest, "copy", "UT4"))
+	if err != nil {
+		t.Fatalf("Expected the symbolic link to be recreated: %s", err.Error())
+	}
+	if link != filepath.Join("Binaries", "UE4-Linux-Shipping") {
+		t.Errorf("Expected the link to the binary, got '%s'", link)
+	}
+
+	// Errors are returned instead of leaving an incomplete copy
+	err = os.Chmod(filepath.Join(source, "Binaries"), 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Chmod(filepath.Join(source, "Binaries"), 0755)
+	if os.Geteuid() == 0 {
+		t.Skip("Permissions are not enforced for root")
+	}
+	err = CopyDir(source, filepath.Join(dest, "failed"))
+	if err == nil {
+		t.Error("Expected an error for an unreadable directory")
+	}
+}
+
+// countingJob counts the number of times it is processed
+type countingJob struct {
+	processed *int32
+}
+
+func (job countingJob) Process() {
+	atomic.AddInt32(job.processed, 1)
+}
+
+func TestRunJobs(t *testing.T) {
+	var processed int32
+	jobs := make([]job, 10)
+	for i := range jobs {
+		jobs[i] = countingJob{processed: &processed}
+	}
+	// The wait group is only done once every worker has exited
+	exited := make(chan struct{})
+	go func() {
+		runJobs(3, jobs).Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the workers to exit once the jobs are processed")
+	}
+	if processed != int32(len(jobs)) {
+		t.Errorf("Expected %d jobs to be processed, got %d", len(jobs), processed)
+	}
+}
@@ -0,0 +1,94 @@
+package ut4updater
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/sethgrid/pester"
+)
+
+const (
+	// defaultUserAgent is sent with every request to the update server
+	defaultUserAgent = "ut4-updater"
+	// defaultHTTPTimeout limits requests to the update server, package
+	// downloads use the download timeouts instead
+	defaultHTTPTimeout = 30 * time.Second
+)
+
+// RetryPolicy configures how requests to the update server are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of attempts made for a request
+	MaxRetries int
+	// Backoff returns the time to wait before the given retry
+	Backoff func(retry int) time.Duration
+}
+
+// DefaultRetryPolicy is used when no retry policy is configured
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	Backoff:    pester.DefaultBackoff,
+}
+
+// doRequest sends the request to the update server with the configured
+// client and retry policy. Responses without a 2XX status code are
+// returned as an HTTPStatusError
+func (updater *UT4Updater) doRequest(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", updater.userAgent)
+
+	client := pester.New()
+	client.Transport = updater.httpClient.Transport
+	client.CheckRedirect = updater.httpClient.CheckRedirect
+	client.Jar = updater.httpClient.Jar
+	client.Timeout = updater.httpClient.Timeout
+	client.Concurrency = 1
+	client.MaxRetries = updater.retryPolicy.MaxRetries
+	if client.MaxRetries < 1 {
+		client.MaxRetries = 1
+	}
+	client.Backoff = pester.DefaultBackoff
+	if updater.retryPolicy.Backoff != nil {
+		client.Backoff = updater.retryPolicy.Backoff
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		return nil, newError(ErrServerUnavailable, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &HTTPStatusError{
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	return resp, nil
+}
+
+// get sends a GET request for url to the update server
+func (updater *UT4Updater) get(
+	ctx context.Context,
+	url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return updater.doRequest(req.WithContext(ctx))
+}
+
+// newDownloadClient returns a grab client for package downloads using the
+// configured transport. The client timeout is not used since packages
+// take much longer to download than other requests
+func (updater *UT4Updater) newDownloadClient() *grab.Client {
+	httpClient := *updater.httpClient
+	httpClient.Timeout = 0
+	client := grab.NewClient()
+	client.HTTPClient = &httpClient
+	client.UserAgent = updater.userAgent
+	return client
+}
@@ -0,0 +1,64 @@
+package ut4updater
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the user agent of every request
+type recordingTransport struct {
+	userAgents []string
+}
+
+func (transport *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.userAgents = append(transport.userAgents, req.Header.Get("User-Agent"))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(installPath)
+
+	transport := &recordingTransport{}
+	_, err = New(installPath, 2, "latest", false, updater.updateURL,
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithUserAgent("ut4-launcher/1.0"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(transport.userAgents) == 0 {
+		t.Fatal("The custom transport must be used")
+	}
+	if transport.userAgents[0] != "ut4-launcher/1.0" {
+		t.Errorf("Sent user agent '%s'. Expected '%s'",
+			transport.userAgents[0],
+			"ut4-launcher/1.0")
+	}
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	previousPolicy := updater.retryPolicy
+	defer func() { updater.retryPolicy = previousPolicy }()
+	updater.retryPolicy = RetryPolicy{
+		MaxRetries: 2,
+		Backoff:    func(retry int) time.Duration { return time.Millisecond },
+	}
+
+	_, err := updater.getRemoteVersionHashes(context.Background(), "missing")
+	statusErr, ok := err.(*HTTPStatusError)
+	if !ok {
+		t.Fatalf("Expected an HTTPStatusError, got '%v'", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Returned status code %d. Expected %d",
+			statusErr.StatusCode,
+			http.StatusNotFound)
+	}
+}
@@ -0,0 +1,146 @@
+package ut4updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestFile is the manifest in the metadata directory
+const manifestFile = "manifest.json"
+
+// manifestVersion is increased when the manifest format changes
+const manifestVersion = 1
+
+// The sources a version can be installed from
+const (
+	// ManifestSourceUpdate is a version cloned from the previous
+	// version and updated
+	ManifestSourceUpdate = "update"
+	// ManifestSourceInPlaceUpdate is a version updated in place
+	ManifestSourceInPlaceUpdate = "update-in-place"
+	// ManifestSourceLocal is a version that wasn't installed by the updater,
+	// its manifest was created by hashing the installed files
+	ManifestSourceLocal = "local"
+)
+
+// ManifestFile is a file of an installed version
+type ManifestFile struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Manifest describes what an installed version contains, it's written to
+// the version directory when the version is installed or updated
+type Manifest struct {
+	ManifestVersion int       `json:"manifest_version"`
+	Version         string    `json:"version"`
+	SemVer          string    `json:"semver"`
+	Channel         string    `json:"channel,omitempty"`
+	InstalledAt     time.Time `json:"installed_at"`
+	Source          string    `json:"source"`
+	// Files are keyed by their slash separated path relative to
+	// the version directory
+	Files map[string]ManifestFile `json:"files"`
+}
+
+// readManifest reads the manifest of the version in installPath
+func readManifest(installPath string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(
+		filepath.Join(installPath, metadataDir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// writeManifest creates and writes the manifest of the version in
+// installPath with the given file hashes
+func writeManifest(
+	installPath string,
+	versionMap VersionMap,
+	source string,
+	hashes map[string]string) (*Manifest, error) {
+
+	manifest := &Manifest{
+		ManifestVersion: manifestVersion,
+		Version:         versionMap.Version,
+		SemVer:          versionMap.SemVer,
+		Channel:         versionMap.Channel,
+		InstalledAt:     time.Now().UTC(),
+		Source:          source,
+		Files:           make(map[string]ManifestFile),
+	}
+	for relativePath, hash := range hashes {
+		fileInfo, err := os.Stat(
+			filepath.Join(installPath, filepath.FromSlash(relativePath)))
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files[relativePath] = ManifestFile{
+			SHA256: hash,
+			Size:   fileInfo.Size(),
+		}
+	}
+	err := writeMetadataFile(installPath, manifestFile, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// GenerateManifest hashes the installed version and writes its manifest,
+// this is used for versions that weren't installed by the updater
+func (updater *UT4Updater) GenerateManifest(version string) (*Manifest, error) {
+	return updater.GenerateManifestContext(context.Background(), version)
+}
+
+// GenerateManifestContext is GenerateManifest with a context
+func (updater *UT4Updater) GenerateManifestContext(
+	ctx context.Context,
+	version string) (*Manifest, error) {
+	installedVersion, err := updater.getInstalledVersion(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := updater.hashInstall(
+		ctx,
+		installedVersion.Path,
+		updater.forceRehash,
+		version,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	return writeManifest(
+		installedVersion.Path,
+		installedVersion.VersionMap,
+		ManifestSourceLocal,
+		hashes)
+}
+
+// getInstalledVersion returns the installed version with the build version
+func (updater *UT4Updater) getInstalledVersion(
+	ctx context.Context,
+	version string) (UT4Version, error) {
+	versions, err := updater.GetVersionListContext(ctx)
+	if err != nil {
+		return UT4Version{}, err
+	}
+	for _, installedVersion := range versions {
+		if installedVersion.Version == version {
+			return installedVersion, nil
+		}
+	}
+	return UT4Version{}, newError(ErrVersionNotInstalled,
+		fmt.Errorf("Version '%s' is not installed", version))
+}
@@ -0,0 +1,48 @@
+package ut4updater
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGenerateManifest(t *testing.T) {
+	_, restore := useTestInstall(t)
+	defer restore()
+
+	manifest, err := updater.GenerateManifest("003")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if manifest.Source != ManifestSourceLocal {
+		t.Errorf("Expected source '%s', got '%s'", ManifestSourceLocal, manifest.Source)
+	}
+	file, ok := manifest.Files["UT4.txt"]
+	if !ok {
+		t.Fatal("UT4.txt must be in the manifest")
+	}
+	if file.Size != 19 || len(file.SHA256) != 64 {
+		t.Errorf("Unexpected manifest entry for UT4.txt %+v", file)
+	}
+	if _, ok := manifest.Files[metadataDir+"/"+manifestFile]; ok {
+		t.Error("The manifest must not list itself")
+	}
+
+	versions, err := updater.GetVersionList()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, version := range versions {
+		if version.Version == "003" && version.Manifest == nil {
+			t.Error("GetVersionList must read the manifest of 003")
+		}
+		if version.Version != "003" && version.Manifest != nil {
+			t.Errorf("Version %s must not have a manifest", version.Version)
+		}
+	}
+
+	_, err = updater.GenerateManifestContext(context.Background(), "999")
+	if !errors.Is(err, ErrVersionNotInstalled) {
+		t.Errorf("Expected ErrVersionNotInstalled, got '%v'", err)
+	}
+}
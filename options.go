@@ -0,0 +1,100 @@
+package ut4updater
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Option configures optional settings of UT4Updater in New
+type Option func(updater *UT4Updater) error
+
+// WithPublicKey sets the ed25519 public key used to verify the signed
+// responses from the update server. Without a public key responses
+// are not verified
+func WithPublicKey(publicKey ed25519.PublicKey) Option {
+	return func(updater *UT4Updater) error {
+		if len(publicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("The public key must be %d bytes", ed25519.PublicKeySize)
+		}
+		updater.publicKey = publicKey
+		return nil
+	}
+}
+
+// WithDownloadTimeout limits the total duration of a package download,
+// 0 disables the limit which is the default
+func WithDownloadTimeout(timeout time.Duration) Option {
+	return func(updater *UT4Updater) error {
+		updater.downloadTimeout = timeout
+		return nil
+	}
+}
+
+// WithDownloadIdleTimeout stops a package download when no data was
+// received for the given duration, 0 disables the limit. Defaults to
+// a minute
+func WithDownloadIdleTimeout(timeout time.Duration) Option {
+	return func(updater *UT4Updater) error {
+		updater.downloadIdleTimeout = timeout
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used for every request to the update
+// server. The client's transport, proxy and timeout are used, the timeout
+// doesn't apply to package downloads
+func WithHTTPClient(client *http.Client) Option {
+	return func(updater *UT4Updater) error {
+		if client == nil {
+			return errors.New("The HTTP client must not be nil")
+		}
+		updater.httpClient = client
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent sent to the update server
+func WithUserAgent(userAgent string) Option {
+	return func(updater *UT4Updater) error {
+		updater.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithRetryPolicy sets how failed requests to the update server
+// are retried
+func WithRetryPolicy(retryPolicy RetryPolicy) Option {
+	return func(updater *UT4Updater) error {
+		updater.retryPolicy = retryPolicy
+		return nil
+	}
+}
+
+// WithChannel sets the release channel updates are installed from,
+// defaults to ChannelStable
+func WithChannel(channel string) Option {
+	return func(updater *UT4Updater) error {
+		return updater.SetChannel(channel)
+	}
+}
+
+// WithForceRehash ignores the hash cache so every file of the installed
+// version is hashed again on update. The cache is rebuilt afterwards
+func WithForceRehash(force bool) Option {
+	return func(updater *UT4Updater) error {
+		updater.forceRehash = force
+		return nil
+	}
+}
+
+// WithFileDownloads sets whether the files of an update are downloaded one
+// by one when the update server has no package for it, enabled by default
+func WithFileDownloads(enabled bool) Option {
+	return func(updater *UT4Updater) error {
+		updater.fileDownloads = enabled
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package ut4updater
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// packageCacheDir is the directory under the install path where update
+// packages are downloaded to. Partial downloads are kept here so they can
+// be resumed by the next update
+var packageCacheDir = filepath.Join(".cache", "packages")
+
+// getPackageCachePath returns the path the package for the update command
+// is downloaded to, packages are identified by their SHA256 hash
+func (updater *UT4Updater) getPackageCachePath(
+	updateCommand UpdateCommand) (string, error) {
+	cachePath := filepath.Join(updater.installPath, packageCacheDir)
+	err := os.MkdirAll(cachePath, 0755)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(
+		cachePath,
+		fmt.Sprintf("%s.tar.gz", updateCommand.SHA256)), nil
+}
+
+// getFileCachePath returns the directory the files for the delta hash are
+// downloaded to when the update server has no package for it, files are
+// identified by their SHA256 hash
+func (updater *UT4Updater) getFileCachePath(deltaHash string) string {
+	return filepath.Join(
+		updater.installPath,
+		packageCacheDir,
+		fmt.Sprintf("%s.files", deltaHash))
+}
+
+// cleanPackageCache removes all cached packages except keepPaths, these are
+// left behind by downloads for updates that are no longer needed
+func (updater *UT4Updater) cleanPackageCache(keepPaths ...string) error {
+	cachePath := filepath.Join(updater.installPath, packageCacheDir)
+	files, err := ioutil.ReadDir(cachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, keepPath := range keepPaths {
+		keep[keepPath] = true
+	}
+	for _, file := range files {
+		path := filepath.Join(cachePath, file.Name())
+		if keep[path] {
+			continue
+		}
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExistingDownload validates a previous download at savePath before it
+// is reused. Returns true if the download is complete and matches the
+// update command. Downloads that can't be resumed are removed, which
+// includes every incomplete download when the size isn't known
+func checkExistingDownload(
+	savePath string,
+	updateCommand UpdateCommand) (bool, error) {
+
+	fileInfo, err := os.Stat(savePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if updateCommand.Size <= 0 {
+		// A partial download can't be told apart from a corrupt one
+		hash, err := hashFile(savePath)
+		if err != nil {
+			return false, err
+		}
+		if hash == updateCommand.SHA256 {
+			return true, nil
+		}
+		return false, os.Remove(savePath)
+	}
+	if fileInfo.Size() < updateCommand.Size {
+		// Partial download that can be resumed
+		return false, nil
+	}
+	if fileInfo.Size() == updateCommand.Size {
+		hash, err := hashFile(savePath)
+		if err != nil {
+			return false, err
+		}
+		if hash == updateCommand.SHA256 {
+			return true, nil
+		}
+	}
+	// Too large or corrupt, start over
+	return false, os.Remove(savePath)
+}
+
+// hashFile returns the hex encoded SHA256 hash of the file at path
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -1,17 +1,163 @@
 package ut4updater
 
-// ProgressTracker implements a writer for tracking the
-// progress of hash generation and file downloads
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressTracker counts the bytes read or written through it against the
+// expected total. It's safe to poll from another goroutine while the
+// transfer is running and is used for hashing, copying and downloading
 type ProgressTracker struct {
-	progress   chan int
-	totalBytes int64
+	total    int64
+	complete int64
+	started  time.Time
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// transferProgress is implemented by ProgressTracker and grab responses
+type transferProgress interface {
+	Progress() float64
+	BytesPerSecond() float64
+	ETA() time.Time
+}
+
+// NewProgressTracker returns a tracker for a transfer of total bytes
+func NewProgressTracker(total int64) *ProgressTracker {
+	return &ProgressTracker{
+		total:   total,
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+}
+
+// Reader returns a reader that tracks the bytes read from reader, the
+// tracker completes when reader returns io.EOF or fails
+func (pt *ProgressTracker) Reader(reader io.Reader) io.Reader {
+	return progressReader{tracker: pt, reader: reader}
+}
+
+// Writer returns a writer that tracks the bytes written to writer, the
+// tracker completes when the total has been written or writer fails
+func (pt *ProgressTracker) Writer(writer io.Writer) io.Writer {
+	return progressWriter{tracker: pt, writer: writer}
+}
+
+// Finish completes the tracker with err, only the first call has an effect
+func (pt *ProgressTracker) Finish(err error) {
+	pt.once.Do(func() {
+		pt.err = err
+		close(pt.done)
+	})
+}
+
+// Done is closed when the tracker completes
+func (pt *ProgressTracker) Done() <-chan struct{} {
+	return pt.done
+}
+
+// Err returns the error the tracker completed with
+func (pt *ProgressTracker) Err() error {
+	select {
+	case <-pt.done:
+		return pt.err
+	default:
+		return nil
+	}
+}
+
+// BytesComplete returns the number of bytes transferred so far
+func (pt *ProgressTracker) BytesComplete() int64 {
+	return atomic.LoadInt64(&pt.complete)
+}
+
+// Progress returns the ratio of bytes transferred to the total
+func (pt *ProgressTracker) Progress() float64 {
+	if pt.total <= 0 {
+		select {
+		case <-pt.done:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return float64(pt.BytesComplete()) / float64(pt.total)
+}
+
+// BytesPerSecond returns the average transfer rate
+func (pt *ProgressTracker) BytesPerSecond() float64 {
+	elapsed := time.Since(pt.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(pt.BytesComplete()) / elapsed
+}
+
+// ETA returns the estimated time the transfer completes, the zero time
+// is returned while no bytes have been transferred
+func (pt *ProgressTracker) ETA() time.Time {
+	bytesPerSecond := pt.BytesPerSecond()
+	if bytesPerSecond == 0 {
+		return time.Time{}
+	}
+	bytesLeft := pt.total - pt.BytesComplete()
+	if bytesLeft < 0 {
+		bytesLeft = 0
+	}
+	return time.Now().Add(
+		time.Duration(float64(bytesLeft) / bytesPerSecond * float64(time.Second)))
+}
+
+func (pt *ProgressTracker) add(n int) {
+	if n > 0 {
+		atomic.AddInt64(&pt.complete, int64(n))
+	}
+}
+
+type progressReader struct {
+	tracker *ProgressTracker
+	reader  io.Reader
+}
+
+func (pr progressReader) Read(data []byte) (int, error) {
+	n, err := pr.reader.Read(data)
+	pr.tracker.add(n)
+	if err == io.EOF {
+		pr.tracker.Finish(nil)
+	} else if err != nil {
+		pr.tracker.Finish(err)
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	tracker *ProgressTracker
+	writer  io.Writer
+}
+
+func (pw progressWriter) Write(data []byte) (int, error) {
+	n, err := pw.writer.Write(data)
+	pw.tracker.add(n)
+	if err != nil {
+		pw.tracker.Finish(err)
+	} else if pw.tracker.BytesComplete() >= pw.tracker.total {
+		pw.tracker.Finish(nil)
+	}
+	return n, err
 }
 
-func (pt ProgressTracker) Write(data []byte) (int, error) {
-	pt.progress <- len(data)
-	// TODO: Fix this magic number, it's the buffer size
-	if len(data) < 32768 {
-		close(pt.progress)
+// progressStats returns the MB/s, the estimated seconds remaining and
+// the percentage complete of a transfer
+func progressStats(progress transferProgress) (float64, float64, float64) {
+	eta := time.Until(progress.ETA()).Seconds()
+	if eta < 0 {
+		eta = 0
 	}
-	return len(data), nil
+	return progress.BytesPerSecond() / 1024.00 / 1024.00,
+		eta,
+		progress.Progress() * 100.00
 }
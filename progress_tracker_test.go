@@ -0,0 +1,92 @@
+package ut4updater
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestProgressTrackerReader(t *testing.T) {
+	data := bytes.Repeat([]byte("ut4"), 40000)
+	tracker := NewProgressTracker(int64(len(data)))
+	// A one byte reader must be counted the same as any other buffer size
+	reader := tracker.Reader(iotest.OneByteReader(bytes.NewReader(data)))
+	copied, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	select {
+	case <-tracker.Done():
+	default:
+		t.Fatal("The tracker must complete on EOF")
+	}
+	if tracker.Err() != nil {
+		t.Errorf("Expected no error, got '%v'", tracker.Err())
+	}
+	if tracker.BytesComplete() != copied || copied != int64(len(data)) {
+		t.Errorf("Expected %d bytes, tracked %d", len(data), tracker.BytesComplete())
+	}
+	if tracker.Progress() != 1 {
+		t.Errorf("Expected progress 1, got %f", tracker.Progress())
+	}
+}
+
+func TestProgressTrackerEmpty(t *testing.T) {
+	tracker := NewProgressTracker(0)
+	if tracker.Progress() != 0 {
+		t.Errorf("Expected progress 0 before EOF, got %f", tracker.Progress())
+	}
+	_, err := io.Copy(ioutil.Discard, tracker.Reader(bytes.NewReader(nil)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if tracker.Progress() != 1 {
+		t.Errorf("Expected progress 1 after EOF, got %f", tracker.Progress())
+	}
+}
+
+func TestProgressTrackerWriter(t *testing.T) {
+	tracker := NewProgressTracker(10)
+	writer := tracker.Writer(ioutil.Discard)
+	writer.Write([]byte("ut4"))
+	select {
+	case <-tracker.Done():
+		t.Fatal("The tracker must not complete before the total is written")
+	default:
+	}
+	writer.Write([]byte("updater"))
+	select {
+	case <-tracker.Done():
+	default:
+		t.Fatal("The tracker must complete once the total is written")
+	}
+	// Finishing again has no effect
+	tracker.Finish(errors.New("Too late"))
+	if tracker.Err() != nil {
+		t.Errorf("Expected no error, got '%v'", tracker.Err())
+	}
+}
+
+// failingReader returns err on every read
+type failingReader struct {
+	err error
+}
+
+func (reader failingReader) Read(data []byte) (int, error) {
+	return 0, reader.err
+}
+
+func TestProgressTrackerError(t *testing.T) {
+	readErr := errors.New("Read failed")
+	tracker := NewProgressTracker(100)
+	_, err := io.Copy(ioutil.Discard, tracker.Reader(failingReader{err: readErr}))
+	if err != readErr {
+		t.Errorf("Expected the read error, got '%v'", err)
+	}
+	if tracker.Err() != readErr {
+		t.Errorf("Expected the tracker to fail with the read error, got '%v'", tracker.Err())
+	}
+}
@@ -0,0 +1,113 @@
+package ut4updater
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// cleanEntryName returns the cleaned slash separated name for a package
+// entry or delta file, names that are absolute or leave the install
+// path are rejected
+func cleanEntryName(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", &UnsafeEntryError{Name: name, Reason: "contains a NUL byte"}
+	}
+	slashName := strings.Replace(name, "\\", "/", -1)
+	if path.IsAbs(slashName) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &UnsafeEntryError{Name: name, Reason: "absolute path"}
+	}
+	cleanName := path.Clean(slashName)
+	if cleanName == "." || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+		return "", &UnsafeEntryError{Name: name, Reason: "outside the install path"}
+	}
+	return cleanName, nil
+}
+
+// isRootEntry returns true if the package entry name is the root of the
+// package, like the './' entry of archives created with 'tar -C dir .'
+func isRootEntry(name string) bool {
+	return path.Clean(strings.Replace(name, "\\", "/", -1)) == "."
+}
+
+// safeJoin joins the entry name to root and makes sure the result stays
+// inside root, also when following the symbolic links already in root
+func safeJoin(root string, name string) (string, error) {
+	cleanName, err := cleanEntryName(name)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(root, filepath.FromSlash(cleanName))
+	if !isWithin(root, target) {
+		return "", &UnsafeEntryError{Name: name, Reason: "outside the install path"}
+	}
+	err = checkResolvedParent(root, name, target)
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// isWithin returns true if target is root or lexically inside root
+func isWithin(root string, target string) bool {
+	return target == root ||
+		strings.HasPrefix(target, root+string(filepath.Separator))
+}
+
+// checkResolvedParent makes sure the existing parent directories of
+// target, the path of the entry name, don't resolve outside root through
+// symbolic links
+func checkResolvedParent(root string, name string, target string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	// Find the deepest parent that already exists
+	parent := filepath.Dir(target)
+	for isWithin(root, parent) {
+		if _, err := os.Lstat(parent); err == nil {
+			break
+		}
+		parent = filepath.Dir(parent)
+	}
+	resolvedParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return err
+	}
+	if !isWithin(resolvedRoot, resolvedParent) {
+		return &UnsafeEntryError{
+			Name:   name,
+			Reason: "resolves outside the install path"}
+	}
+	return nil
+}
+
+// resolveLinkTarget returns the path linkname resolves to from dir, the
+// symbolic links that already exist along the way are followed so links
+// created earlier can't be chained to leave the install path
+func resolveLinkTarget(dir string, linkname string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, part := range strings.Split(filepath.ToSlash(linkname), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		resolved = filepath.Join(resolved, part)
+		fileInfo, err := os.Lstat(resolved)
+		if err != nil || fileInfo.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		resolved, err = filepath.EvalSymlinks(resolved)
+		if err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,134 @@
+package ut4updater
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semVer is a parsed semantic version, build metadata is dropped since it
+// doesn't affect precedence
+type semVer struct {
+	major      uint64
+	minor      uint64
+	patch      uint64
+	preRelease []string
+}
+
+// parseSemVer parses a semantic version as defined by semver.org, a
+// leading 'v' is allowed
+func parseSemVer(version string) (semVer, bool) {
+	version = strings.TrimPrefix(version, "v")
+	if index := strings.Index(version, "+"); index >= 0 {
+		version = version[:index]
+	}
+	var parsed semVer
+	if index := strings.Index(version, "-"); index >= 0 {
+		parsed.preRelease = strings.Split(version[index+1:], ".")
+		for _, identifier := range parsed.preRelease {
+			if identifier == "" {
+				return semVer{}, false
+			}
+		}
+		version = version[:index]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return semVer{}, false
+	}
+	numbers := make([]uint64, 3)
+	for i, part := range parts {
+		number, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return semVer{}, false
+		}
+		numbers[i] = number
+	}
+	parsed.major, parsed.minor, parsed.patch = numbers[0], numbers[1], numbers[2]
+	return parsed, true
+}
+
+// compareSemVer returns -1, 0 or 1 if a has a lower, equal or higher
+// precedence than b. Invalid versions have a lower precedence than valid
+// ones and are compared as strings
+func compareSemVer(a string, b string) int {
+	parsedA, okA := parseSemVer(a)
+	parsedB, okB := parseSemVer(b)
+	switch {
+	case !okA && !okB:
+		return strings.Compare(a, b)
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	if result := compareUint(parsedA.major, parsedB.major); result != 0 {
+		return result
+	}
+	if result := compareUint(parsedA.minor, parsedB.minor); result != 0 {
+		return result
+	}
+	if result := compareUint(parsedA.patch, parsedB.patch); result != 0 {
+		return result
+	}
+	return comparePreRelease(parsedA.preRelease, parsedB.preRelease)
+}
+
+// comparePreRelease compares pre-release identifiers, a version without a
+// pre-release has a higher precedence than one with
+func comparePreRelease(a []string, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		numberA, errA := strconv.ParseUint(a[i], 10, 64)
+		numberB, errB := strconv.ParseUint(b[i], 10, 64)
+		var result int
+		switch {
+		case errA == nil && errB == nil:
+			result = compareUint(numberA, numberB)
+		case errA == nil:
+			// Numeric identifiers have a lower precedence
+			result = -1
+		case errB == nil:
+			result = 1
+		default:
+			result = strings.Compare(a[i], b[i])
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return compareUint(uint64(len(a)), uint64(len(b)))
+}
+
+// compareBuild returns -1, 0 or 1 if build version a is lower, equal or
+// higher than b. Numeric builds are compared as numbers and are higher
+// than builds that aren't numeric, which are equal to each other
+func compareBuild(a string, b string) int {
+	numberA, errA := strconv.ParseUint(a, 10, 64)
+	numberB, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return compareUint(numberA, numberB)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	return 0
+}
+
+func compareUint(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package ut4updater
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCompareSemVer(t *testing.T) {
+	// The precedence example from semver.org, lowest first
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if compareSemVer(ordered[i], ordered[i+1]) != -1 {
+			t.Errorf("'%s' must be lower than '%s'", ordered[i], ordered[i+1])
+		}
+		if compareSemVer(ordered[i+1], ordered[i]) != 1 {
+			t.Errorf("'%s' must be higher than '%s'", ordered[i+1], ordered[i])
+		}
+	}
+	if compareSemVer("1.0.0+build.1", "v1.0.0+build.2") != 0 {
+		t.Error("Build metadata and a leading 'v' must be ignored")
+	}
+	if compareSemVer("", "0.0.1") != -1 {
+		t.Error("An invalid version must be lower than a valid version")
+	}
+}
+
+func TestByVersion(t *testing.T) {
+	versions := []UT4Version{
+		{VersionMap: VersionMap{Version: "9", SemVer: "0.9.0"}},
+		{VersionMap: VersionMap{Version: "10", SemVer: "1.0.0-rc.1"}},
+		{VersionMap: VersionMap{Version: "10", SemVer: "1.0.0"}},
+		{VersionMap: VersionMap{Version: "preview", SemVer: "2.0.0"}},
+		{VersionMap: VersionMap{Version: "100", SemVer: "1.1.0"}},
+	}
+	sort.Sort(ByVersion(versions))
+	expected := []string{"1.1.0", "1.0.0", "1.0.0-rc.1", "0.9.0", "2.0.0"}
+	for i, version := range versions {
+		if version.SemVer != expected[i] {
+			t.Errorf("Expected %s at %d, got %s", expected[i], i, version.SemVer)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ut4updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// signatureHeader is the response header containing the base64 encoded
+// detached ed25519 signature of the response body
+const signatureHeader = "X-Signature"
+
+// signedMessage returns the message the update server signs for a response,
+// the requested resource is signed with the body so a signed response can't
+// be replayed for another version or delta hash
+func signedMessage(resource string, body []byte) []byte {
+	message := make([]byte, 0, len(resource)+1+len(body))
+	message = append(message, resource...)
+	message = append(message, '\n')
+	return append(message, body...)
+}
+
+// verifySignature verifies the base64 encoded ed25519 signature of the body
+// returned for resource against the configured public key. Nothing is
+// verified when no public key is configured
+func (updater *UT4Updater) verifySignature(
+	resource string,
+	body []byte,
+	signature string) error {
+	if updater.publicKey == nil {
+		return nil
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(
+		strings.TrimSpace(signature))
+	if err != nil || len(signatureBytes) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(
+		updater.publicKey,
+		signedMessage(resource, body),
+		signatureBytes) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// getSigned retrieves the resource, a path relative to the update URL, and
+// verifies its signature. The body and signature are returned
+func (updater *UT4Updater) getSigned(
+	ctx context.Context,
+	resource string) ([]byte, string, error) {
+	response, err := updater.get(ctx,
+		fmt.Sprintf("%s/%s", updater.updateURL, resource))
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	signature := response.Header.Get(signatureHeader)
+	err = updater.verifySignature(resource, body, signature)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, signature, nil
+}
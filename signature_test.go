@@ -0,0 +1,130 @@
+package ut4updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newSignedTestServer returns a server that signs the version map and
+// version hashes with privateKey
+func newSignedTestServer(privateKey ed25519.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		switch r.URL.EscapedPath() {
+		case "/update/ut4-versionmap":
+			var err error
+			body, err = ioutil.ReadFile("./test-resources/installs/versionmap.json")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		case "/update/ut4-hash/003", "/update/ut4-hash/004":
+			body = []byte("{\"UT4.txt\": \"6437d1a60d30af1f9ced59ee8fea2f619c04e79f8ec77e36083af108fbc8f401\"}")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resource := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+		if resource == "update/ut4-hash/004" {
+			// A replayed response signed for another version
+			resource = "update/ut4-hash/003"
+		}
+		w.Header().Set(signatureHeader, base64.StdEncoding.EncodeToString(
+			ed25519.Sign(privateKey, signedMessage(resource, body))))
+		w.Write(body)
+	}))
+}
+
+func TestSignedResponses(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	testServer := newSignedTestServer(privateKey)
+	defer testServer.Close()
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(installPath)
+
+	signedUpdater, err := New(installPath, 2, "latest", false, testServer.URL,
+		WithPublicKey(publicKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(signedUpdater.versionMaps) == 0 {
+		t.Error("The signed version map must be loaded")
+	}
+	_, err = signedUpdater.getRemoteVersionHashes(context.Background(), "003")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	// A response signed for another resource is rejected
+	_, err = signedUpdater.getRemoteVersionHashes(context.Background(), "004")
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a replayed response, got '%v'", err)
+	}
+
+	// An invalid remote version map is not replaced by the local copy
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	otherServer := newSignedTestServer(otherPrivateKey)
+	defer otherServer.Close()
+	signedUpdater.updateURL = otherServer.URL
+	err = signedUpdater.updateVersionMap(context.Background())
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for the remote version map, got '%v'", err)
+	}
+
+	// The cached version map is verified when the server is not available
+	signedUpdater.updateURL = "httx://localhost"
+	err = signedUpdater.updateVersionMap(context.Background())
+	if err != nil {
+		t.Errorf("The signed local version map must be used, got '%s'", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(installPath, "versionmap.json"),
+		[]byte("[{\"version\":\"999\"}]"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = signedUpdater.updateVersionMap(context.Background())
+	if err == nil {
+		t.Error("A modified local version map must fail")
+	}
+}
+
+func TestSignedResponsesWrongKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, otherPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	testServer := newSignedTestServer(otherPrivateKey)
+	defer testServer.Close()
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(installPath)
+
+	_, err = New(installPath, 2, "latest", false, testServer.URL,
+		WithPublicKey(publicKey))
+	if err == nil {
+		t.Fatal("A version map signed with another key must fail")
+	}
+}
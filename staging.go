@@ -0,0 +1,73 @@
+package ut4updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	stagingSuffix = ".staging"
+	backupSuffix  = ".backup"
+)
+
+// stagingPaths returns the paths used to stage an update for installPath
+// and to keep the previous install while it is swapped into place
+func stagingPaths(installPath string) (string, string) {
+	base := filepath.Join(
+		filepath.Dir(installPath),
+		"."+filepath.Base(installPath))
+	return base + stagingSuffix, base + backupSuffix
+}
+
+// recoverStagedUpdate finishes or rolls back an update of installPath that
+// was interrupted while being swapped into place
+func recoverStagedUpdate(installPath string) error {
+	stagingPath, backupPath := stagingPaths(installPath)
+	if _, err := os.Stat(backupPath); err == nil {
+		if _, err := os.Stat(installPath); os.IsNotExist(err) {
+			// Interrupted between the renames, the staged update might
+			// not be complete so the previous install is restored
+			err = os.Rename(backupPath, installPath)
+			if err != nil {
+				return err
+			}
+		} else {
+			// The update was swapped in but the backup wasn't removed
+			err = os.RemoveAll(backupPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(stagingPath)
+}
+
+// recoverStagedUpdates recovers all versions in the install path that were
+// interrupted during an update
+func (updater *UT4Updater) recoverStagedUpdates() error {
+	files, err := ioutil.ReadDir(updater.installPath)
+	if err != nil {
+		// Nothing installed yet
+		return nil
+	}
+	for _, file := range files {
+		name := file.Name()
+		if !file.IsDir() || !strings.HasPrefix(name, ".") {
+			continue
+		}
+		for _, suffix := range []string{stagingSuffix, backupSuffix} {
+			if strings.HasSuffix(name, suffix) {
+				versionPath := filepath.Join(
+					updater.installPath,
+					strings.TrimSuffix(strings.TrimPrefix(name, "."), suffix))
+				err = recoverStagedUpdate(versionPath)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ type UpdateCheckRequest struct {
 	OS             OSDistribution `json:"os"`
 	Versions       []string       `json:"versions"`
 	CurrentVersion string         `json:"current_version"`
+	Channel        string         `json:"channel"`
 }
 
 // UpdateCheckResponse is the response for update check requests
@@ -24,6 +25,16 @@ type UpdateCheckResponse struct {
 	UpdateAvailable bool   `json:"update_available"`
 }
 
+// UpdateCommand is the response from the update server with the package
+// to download for an update
+type UpdateCommand struct {
+	UpdateURL string `json:"update_url"`
+	// Size of the package in bytes
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded hash of the package
+	SHA256 string `json:"sha256"`
+}
+
 // HashProgressEvent contains the progress event
 type HashProgressEvent struct {
 	Filename string
@@ -46,3 +57,37 @@ type DownloadProgressEvent struct {
 	Percent   float64
 	Completed bool
 }
+
+// ApplyProgressEvent contains the operation performed on a file
+// while applying an update
+type ApplyProgressEvent struct {
+	Filepath  string
+	Operation string
+}
+
+// Update statuses reported through UpdateProgressEvent
+const (
+	UpdateStatusChecking    = "checking"
+	UpdateStatusHashing     = "hashing"
+	UpdateStatusDownloading = "downloading"
+	UpdateStatusCloning     = "cloning"
+	UpdateStatusApplying    = "applying"
+	UpdateStatusPruning     = "pruning"
+	UpdateStatusVerifying   = "verifying"
+	UpdateStatusCompleted   = "completed"
+	UpdateStatusFailed      = "failed"
+)
+
+// UpdateProgressEvent is sent as JSON on the Update feedback channel
+type UpdateProgressEvent struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// MB/s processed, only set while downloading
+	Mbps float64 `json:"mbps"`
+	// The estimated time to complete in seconds
+	ETA       float64 `json:"eta"`
+	Percent   float64 `json:"percent"`
+	Completed bool    `json:"completed"`
+}
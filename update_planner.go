@@ -0,0 +1,244 @@
+package ut4updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The kinds of update plans
+const (
+	// UpdatePlanDelta updates with a single package from the installed
+	// version to the next version
+	UpdatePlanDelta = "delta"
+	// UpdatePlanChain updates through every release in between with a
+	// package per release
+	UpdatePlanChain = "chain"
+	// UpdatePlanFull downloads every file of the next version
+	UpdatePlanFull = "full"
+	// UpdatePlanFiles downloads the added and modified files one by one
+	// when the update server has no packages for the update
+	UpdatePlanFiles = "files"
+)
+
+// updateStep is a package that updates an install to the next version, the
+// command is empty for the steps of a files plan
+type updateStep struct {
+	fromVersion string
+	toVersion   string
+	command     UpdateCommand
+	// operations are applied to the install with the package
+	operations map[string]string
+	// hashes are the hashes of toVersion
+	hashes map[string]string
+	// modes are the permissions of the files of toVersion for a files
+	// plan, nil if the update server doesn't list them
+	modes map[string]os.FileMode
+}
+
+// updatePlan is the packages to apply in order to update to a version
+type updatePlan struct {
+	kind  string
+	steps []updateStep
+}
+
+// size returns the number of bytes to download for the plan
+func (plan updatePlan) size() int64 {
+	var size int64
+	for _, step := range plan.steps {
+		size += step.command.Size
+	}
+	return size
+}
+
+// maxChainHops limits the releases a chain plan goes through, every hop
+// costs a request for its hashes and one for its package
+const maxChainHops = 5
+
+// planUpdate picks the plan that downloads the fewest bytes to update the
+// install with currentHashes to nextVersion. Plans are only considered if
+// the update server has all their packages, when plans are the same size
+// the one with the fewest packages is used. Without any packages the files
+// are downloaded one by one, unless file downloads are disabled or the
+// update server doesn't list the modes for the files that are added
+func (updater *UT4Updater) planUpdate(
+	ctx context.Context,
+	currentVersion string,
+	currentHashes map[string]string,
+	nextVersion string,
+	nextHashes map[string]string) (updatePlan, error) {
+
+	var best updatePlan
+	step, ok, err := updater.planStep(
+		ctx,
+		currentVersion,
+		currentHashes,
+		nextVersion,
+		nextHashes)
+	if err != nil {
+		return updatePlan{}, err
+	}
+	if ok {
+		best = updatePlan{
+			kind:  UpdatePlanDelta,
+			steps: []updateStep{step},
+		}
+	} else {
+		// The full package is the delta from an empty install, applying
+		// it still removes the files that aren't in the next version. It
+		// holds every file of the delta so it's only needed without one
+		step, ok, err = updater.planStep(ctx, "", nil, nextVersion, nextHashes)
+		if err != nil {
+			return updatePlan{}, err
+		}
+		if ok {
+			step.fromVersion = currentVersion
+			step.operations = updater.calculateHashDeltaOperations(
+				currentHashes,
+				nextHashes)
+			best = updatePlan{
+				kind:  UpdatePlanFull,
+				steps: []updateStep{step},
+			}
+		}
+	}
+
+	chain, ok, err := updater.planChain(
+		ctx,
+		currentVersion,
+		currentHashes,
+		nextVersion,
+		nextHashes,
+		best)
+	if err != nil {
+		return updatePlan{}, err
+	}
+	if ok {
+		best = chain
+	}
+
+	if len(best.steps) > 0 {
+		return best, nil
+	}
+	if updater.fileDownloads {
+		operations := updater.calculateHashDeltaOperations(
+			currentHashes,
+			nextHashes)
+		modes, err := updater.getRemoteVersionModes(ctx, nextVersion)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return updatePlan{}, err
+		}
+		if fileModesKnown(operations, modes) {
+			return updatePlan{
+				kind: UpdatePlanFiles,
+				steps: []updateStep{{
+					fromVersion: currentVersion,
+					toVersion:   nextVersion,
+					operations:  operations,
+					hashes:      nextHashes,
+					modes:       modes,
+				}},
+			}, nil
+		}
+	}
+	return updatePlan{}, newError(ErrNotFound,
+		fmt.Errorf("No update packages from version %s to %s",
+			currentVersion,
+			nextVersion))
+}
+
+// planStep returns the step from the install with fromHashes to toVersion,
+// false is returned if the update server doesn't have its package
+func (updater *UT4Updater) planStep(
+	ctx context.Context,
+	fromVersion string,
+	fromHashes map[string]string,
+	toVersion string,
+	toHashes map[string]string) (updateStep, bool, error) {
+
+	operations := updater.calculateHashDeltaOperations(fromHashes, toHashes)
+	updateCommand, err := updater.getUpdateCommand(
+		ctx,
+		updater.generateDeltaHash(operations, toHashes))
+	if errors.Is(err, ErrNotFound) {
+		return updateStep{}, false, nil
+	}
+	if err != nil {
+		return updateStep{}, false, err
+	}
+	return updateStep{
+		fromVersion: fromVersion,
+		toVersion:   toVersion,
+		command:     updateCommand,
+		operations:  operations,
+		hashes:      toHashes,
+	}, true, nil
+}
+
+// planChain returns the plan through every installable release of the
+// channel between the current and the next version. False is returned if
+// there are no releases in between, there are more than maxChainHops, the
+// update server doesn't have all the packages or the chain isn't smaller
+// than best. Packages stop being requested once the chain can't be smaller
+func (updater *UT4Updater) planChain(
+	ctx context.Context,
+	currentVersion string,
+	currentHashes map[string]string,
+	nextVersion string,
+	nextHashes map[string]string,
+	best updatePlan) (updatePlan, bool, error) {
+
+	var hops []string
+	for _, versionMap := range updater.versionMaps.GetVersionMapsBetween(
+		currentVersion,
+		nextVersion) {
+		if versionMap.Yanked ||
+			versionMap.RequiresNewerUpdater() ||
+			versionMap.Version == nextVersion ||
+			versionMap.ReleaseChannel() != updater.channel {
+			continue
+		}
+		hops = append(hops, versionMap.Version)
+	}
+	if len(hops) == 0 || len(hops) > maxChainHops {
+		// Without releases in between that's the single delta
+		return updatePlan{}, false, nil
+	}
+	hops = append(hops, nextVersion)
+
+	// A chain has more packages so it must be smaller to be used
+	isSmaller := func(plan updatePlan) bool {
+		return len(best.steps) == 0 || plan.size() < best.size()
+	}
+	plan := updatePlan{kind: UpdatePlanChain}
+	fromVersion, fromHashes := currentVersion, currentHashes
+	for _, hop := range hops {
+		hopHashes := nextHashes
+		if hop != nextVersion {
+			var err error
+			hopHashes, err = updater.getRemoteVersionHashes(ctx, hop)
+			if errors.Is(err, ErrNotFound) {
+				return updatePlan{}, false, nil
+			}
+			if err != nil {
+				return updatePlan{}, false, err
+			}
+		}
+		step, ok, err := updater.planStep(
+			ctx,
+			fromVersion,
+			fromHashes,
+			hop,
+			hopHashes)
+		if err != nil || !ok {
+			return updatePlan{}, false, err
+		}
+		plan.steps = append(plan.steps, step)
+		if !isSmaller(plan) {
+			return updatePlan{}, false, nil
+		}
+		fromVersion, fromHashes = hop, hopHashes
+	}
+	return plan, true, nil
+}
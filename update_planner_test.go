@@ -0,0 +1,514 @@
+package ut4updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// plannerTestServer serves the hashes of versions 003 to 006 and only the
+// update packages, files and modes that were added to it
+type plannerTestServer struct {
+	*httptest.Server
+	packageDir string
+	mutex      sync.Mutex
+	packages   map[string]UpdateCommand
+	// files are the contents in the file store keyed by hash, with the
+	// number of times they were requested
+	files        map[string]string
+	fileRequests map[string]int
+	// modes are the file modes keyed by version
+	modes map[string]map[string]string
+	// requests counts the requests for every path
+	requests    map[string]int
+	versionMaps VersionMaps
+}
+
+// plannerTestHashes returns the hashes of a test version
+func plannerTestHashes(version string) map[string]string {
+	return map[string]string{
+		"UT4.txt": fmt.Sprintf("%x", sha256.Sum256(
+			[]byte("This is version "+version))),
+		".gitkeep": fmt.Sprintf("%x", sha256.Sum256(nil)),
+	}
+}
+
+func newPlannerTestServer(t *testing.T) *plannerTestServer {
+	packageDir, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := &plannerTestServer{
+		packageDir: packageDir,
+		packages:   make(map[string]UpdateCommand),
+
+		files:        make(map[string]string),
+		fileRequests: make(map[string]int),
+		modes:        make(map[string]map[string]string),
+		requests:     make(map[string]int),
+		versionMaps: VersionMaps{
+			{Version: "003", SemVer: "0.1.0"},
+			{Version: "004", SemVer: "0.4.0"},
+			{Version: "005", SemVer: "0.5.0"},
+			{Version: "006", SemVer: "0.6.0"},
+		},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+		server.mutex.Lock()
+		server.requests[path]++
+		versionMaps := server.versionMaps
+		server.mutex.Unlock()
+		switch {
+		case path == "/update/ut4-versionmap":
+			json.NewEncoder(w).Encode(versionMaps)
+		case path == "/update/ut4-check":
+			json.NewEncoder(w).Encode(UpdateCheckResponse{
+				LatestVersion:   "006",
+				UpdateAvailable: true,
+			})
+		case strings.HasPrefix(path, "/update/ut4-hash/"):
+			version := strings.TrimPrefix(path, "/update/ut4-hash/")
+			if version < "003" || version > "006" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(plannerTestHashes(version))
+		case strings.HasPrefix(path, "/update/ut4-update/"):
+			server.mutex.Lock()
+			updateCommand, ok := server.packages[strings.TrimPrefix(path, "/update/ut4-update/")]
+			server.mutex.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			updateCommand.UpdateURL = fmt.Sprintf("http://%s%s", r.Host, updateCommand.UpdateURL)
+			json.NewEncoder(w).Encode(updateCommand)
+		case strings.HasPrefix(path, "/update/ut4-mode/"):
+			server.mutex.Lock()
+			modes, ok := server.modes[strings.TrimPrefix(path, "/update/ut4-mode/")]
+			server.mutex.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(modes)
+		case strings.HasPrefix(path, "/update/ut4-file/"):
+			hash := strings.TrimPrefix(path, "/update/ut4-file/")
+			server.mutex.Lock()
+			contents, ok := server.files[hash]
+			server.fileRequests[hash]++
+			server.mutex.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			http.ServeContent(w, r, hash, time.Time{}, strings.NewReader(contents))
+		case strings.HasPrefix(path, "/packages/"):
+			http.ServeFile(w, r, filepath.Join(packageDir, filepath.Base(path)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func (server *plannerTestServer) close() {
+	server.Server.Close()
+	os.RemoveAll(server.packageDir)
+}
+
+// addPackage adds the package from the hashes to the version, size
+// overrides the size the package is advertised with if not 0
+func (server *plannerTestServer) addPackage(
+	t *testing.T,
+	fromHashes map[string]string,
+	toVersion string,
+	size int64) {
+	server.writePackage(t, fromHashes, toVersion, size, true)
+}
+
+// addInvalidPackage adds a package from the hashes to the version that
+// downloads fine but can't be applied
+func (server *plannerTestServer) addInvalidPackage(
+	t *testing.T,
+	fromHashes map[string]string,
+	toVersion string) {
+	server.writePackage(t, fromHashes, toVersion, 0, false)
+}
+
+func (server *plannerTestServer) writePackage(
+	t *testing.T,
+	fromHashes map[string]string,
+	toVersion string,
+	size int64,
+	valid bool) {
+	toHashes := plannerTestHashes(toVersion)
+	operations := updater.calculateHashDeltaOperations(fromHashes, toHashes)
+	deltaHash := updater.generateDeltaHash(operations, toHashes)
+
+	packagePath := filepath.Join(server.packageDir, deltaHash+".tar.gz")
+	if valid {
+		writeTestPackage(t, packagePath, map[string]string{
+			"UT4.txt": "This is version " + toVersion,
+		}, false)
+	} else {
+		err := ioutil.WriteFile(packagePath, []byte("Not a package"), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	packageBytes, err := ioutil.ReadFile(packagePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if size == 0 {
+		size = int64(len(packageBytes))
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.packages[deltaHash] = UpdateCommand{
+		UpdateURL: "/packages/" + deltaHash + ".tar.gz",
+		Size:      size,
+		SHA256:    fmt.Sprintf("%x", sha256.Sum256(packageBytes)),
+	}
+}
+
+// addFile adds the contents to the file store under hash
+func (server *plannerTestServer) addFile(hash string, contents string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.files[hash] = contents
+}
+
+// addModes lists the modes of the files of the version
+func (server *plannerTestServer) addModes(version string, modes map[string]string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.modes[version] = modes
+}
+
+// plannerTestCurrentHashes returns the hashes of version 003 of the
+// planner tests. It has an extra file so that its delta to the next
+// version differs from the delta of the last release in a chain
+func plannerTestCurrentHashes() map[string]string {
+	hashes := plannerTestHashes("003")
+	hashes["Extra.txt"] = fmt.Sprintf("%x", sha256.Sum256([]byte("Extra")))
+	return hashes
+}
+
+// newPlannerTestUpdater returns an updater for a copy of the test installs
+// that uses the planner test server
+func newPlannerTestUpdater(t *testing.T, keep uint) (*UT4Updater, *plannerTestServer, func()) {
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = CopyDir("./test-resources/installs", installPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(
+		filepath.Join(installPath, "003", "Extra.txt"),
+		[]byte("Extra"),
+		0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := newPlannerTestServer(t)
+	plannerUpdater, err := New(installPath, keep, "latest", false, server.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return plannerUpdater, server, func() {
+		server.close()
+		os.RemoveAll(installPath)
+	}
+}
+
+func TestPlanUpdate(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	currentHashes := plannerTestCurrentHashes()
+	plan := func() (updatePlan, error) {
+		return plannerUpdater.planUpdate(
+			context.Background(),
+			"003",
+			currentHashes,
+			"006",
+			plannerTestHashes("006"))
+	}
+
+	// Without packages the files are downloaded one by one
+	updatePlan, err := plan()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanFiles {
+		t.Errorf("Expected a files update without packages, got '%s'", updatePlan.kind)
+	}
+	plannerUpdater.fileDownloads = false
+	_, err = plan()
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without file downloads, got '%v'", err)
+	}
+	plannerUpdater.fileDownloads = true
+
+	// Added files can only be downloaded one by one with their modes
+	nextHashes := plannerTestHashes("006")
+	nextHashes["Run.sh"] = nextHashes["UT4.txt"]
+	_, err = plannerUpdater.planUpdate(
+		context.Background(),
+		"003",
+		currentHashes,
+		"006",
+		nextHashes)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without modes, got '%v'", err)
+	}
+	server.addModes("006", map[string]string{"Run.sh": "0755"})
+	updatePlan, err = plannerUpdater.planUpdate(
+		context.Background(),
+		"003",
+		currentHashes,
+		"006",
+		nextHashes)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanFiles || updatePlan.steps[0].modes["Run.sh"] != 0755 {
+		t.Errorf("Expected a files update with the modes, got '%s' %v",
+			updatePlan.kind,
+			updatePlan.steps[0].modes)
+	}
+
+	// Only the full package
+	server.addPackage(t, nil, "006", 3000)
+	updatePlan, err = plan()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanFull {
+		t.Errorf("Expected a full update, got '%s'", updatePlan.kind)
+	}
+	if updatePlan.steps[0].operations["UT4.txt"] != operationModified {
+		t.Error("The full update must be applied as the changes to the install")
+	}
+
+	// A chain of smaller packages
+	server.addPackage(t, currentHashes, "004", 500)
+	server.addPackage(t, plannerTestHashes("004"), "005", 500)
+	server.addPackage(t, plannerTestHashes("005"), "006", 500)
+	updatePlan, err = plan()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanChain || len(updatePlan.steps) != 3 {
+		t.Fatalf("Expected a chain of 3 packages, got '%s' with %d",
+			updatePlan.kind,
+			len(updatePlan.steps))
+	}
+	for i, version := range []string{"004", "005", "006"} {
+		if updatePlan.steps[i].toVersion != version {
+			t.Errorf("Expected step %d to update to %s, got %s",
+				i,
+				version,
+				updatePlan.steps[i].toVersion)
+		}
+	}
+
+	// A single delta of the same size as the chain wins
+	server.addPackage(t, currentHashes, "006", 1500)
+	updatePlan, err = plan()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanDelta || updatePlan.size() != 1500 {
+		t.Errorf("Expected a delta update of 1500 bytes, got '%s' of %d",
+			updatePlan.kind,
+			updatePlan.size())
+	}
+}
+
+func TestUpdateChain(t *testing.T) {
+	for _, keep := range []uint{0, 2} {
+		plannerUpdater, server, cleanup := newPlannerTestUpdater(t, keep)
+		currentHashes := plannerTestCurrentHashes()
+		server.addPackage(t, currentHashes, "004", 0)
+		server.addPackage(t, plannerTestHashes("004"), "005", 0)
+		server.addPackage(t, plannerTestHashes("005"), "006", 0)
+
+		newVersion, err := plannerUpdater.Update(nil)
+		if err != nil {
+			cleanup()
+			t.Fatal(err.Error())
+		}
+		if newVersion.Version != "006" {
+			t.Errorf("Expected version 006, got '%s'", newVersion.Version)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(newVersion.Path, "UT4.txt"))
+		if err != nil {
+			cleanup()
+			t.Fatal(err.Error())
+		}
+		if string(contents) != "This is version 006" {
+			t.Errorf("The chain was not applied, UT4.txt contains '%s'", contents)
+		}
+		_, err = os.Stat(filepath.Join(newVersion.Path, "Extra.txt"))
+		if !os.IsNotExist(err) {
+			t.Error("Extra.txt should have been removed by the chain")
+		}
+		// Only the final version is installed
+		for _, version := range []string{"004", "005"} {
+			_, err := os.Stat(filepath.Join(plannerUpdater.installPath, version))
+			if !os.IsNotExist(err) {
+				t.Errorf("Version %s must not be installed with keep %d", version, keep)
+			}
+		}
+		cleanup()
+	}
+}
+
+func TestUpdateKeepsPackagesOnFailure(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	server.addInvalidPackage(t, plannerTestCurrentHashes(), "006")
+
+	_, err := plannerUpdater.Update(nil)
+	if !errors.Is(err, ErrInvalidPackage) {
+		t.Fatalf("Expected ErrInvalidPackage, got '%v'", err)
+	}
+	// The package is kept for the next attempt
+	cached, _ := ioutil.ReadDir(
+		filepath.Join(plannerUpdater.installPath, packageCacheDir))
+	if len(cached) != 1 {
+		t.Errorf("Expected the package to be kept, got %d cached", len(cached))
+	}
+}
+
+// countRequests returns the number of requests for paths with the prefix
+func (server *plannerTestServer) countRequests(prefix string) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	count := 0
+	for path, requests := range server.requests {
+		if strings.HasPrefix(path, prefix) {
+			count += requests
+		}
+	}
+	return count
+}
+
+func TestPlanUpdateRequests(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	currentHashes := plannerTestCurrentHashes()
+	server.addPackage(t, currentHashes, "006", 400)
+	server.addPackage(t, nil, "006", 3000)
+	server.addPackage(t, currentHashes, "004", 500)
+	server.addPackage(t, plannerTestHashes("004"), "005", 500)
+	server.addPackage(t, plannerTestHashes("005"), "006", 500)
+
+	updatePlan, err := plannerUpdater.planUpdate(
+		context.Background(),
+		"003",
+		currentHashes,
+		"006",
+		plannerTestHashes("006"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanDelta {
+		t.Errorf("Expected a delta update, got '%s'", updatePlan.kind)
+	}
+	// The full package isn't needed with a delta and the chain is given
+	// up once it's as large as the delta
+	if requests := server.countRequests("/update/ut4-update/"); requests != 2 {
+		t.Errorf("Expected 2 package requests, got %d", requests)
+	}
+	if requests := server.countRequests("/update/ut4-hash/005"); requests != 0 {
+		t.Errorf("Expected no hashes of 005 to be requested, got %d", requests)
+	}
+}
+
+func TestPlanUpdateSkipsUninstallableReleases(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 2)
+	defer cleanup()
+	server.mutex.Lock()
+	server.versionMaps[2].MinUpdaterVersion = "99.0.0"
+	server.mutex.Unlock()
+	err := plannerUpdater.updateVersionMap(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	currentHashes := plannerTestCurrentHashes()
+	server.addPackage(t, currentHashes, "004", 500)
+	server.addPackage(t, plannerTestHashes("004"), "005", 500)
+	server.addPackage(t, plannerTestHashes("005"), "006", 500)
+	server.addPackage(t, plannerTestHashes("004"), "006", 500)
+
+	updatePlan, err := plannerUpdater.planUpdate(
+		context.Background(),
+		"003",
+		currentHashes,
+		"006",
+		plannerTestHashes("006"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if updatePlan.kind != UpdatePlanChain || len(updatePlan.steps) != 2 {
+		t.Fatalf("Expected a chain of 2 packages, got '%s' with %d",
+			updatePlan.kind,
+			len(updatePlan.steps))
+	}
+	if updatePlan.steps[0].toVersion != "004" || updatePlan.steps[1].toVersion != "006" {
+		t.Error("The chain must skip version 005 that requires a newer updater")
+	}
+}
+
+func TestUpdateChainInPlaceFailure(t *testing.T) {
+	plannerUpdater, server, cleanup := newPlannerTestUpdater(t, 0)
+	defer cleanup()
+	server.addPackage(t, plannerTestCurrentHashes(), "004", 0)
+	server.addInvalidPackage(t, plannerTestHashes("004"), "005")
+	server.addPackage(t, plannerTestHashes("005"), "006", 0)
+
+	reachedVersion, err := plannerUpdater.Update(nil)
+	if !errors.Is(err, ErrInvalidPackage) {
+		t.Fatalf("Expected ErrInvalidPackage, got '%v'", err)
+	}
+	if !strings.Contains(err.Error(), "stopped at version 004") {
+		t.Errorf("The error must report the version reached, got '%s'", err.Error())
+	}
+	// The install was updated to 004 before the failure
+	if reachedVersion.Version != "004" {
+		t.Fatalf("Expected version 004 to be returned, got '%s'", reachedVersion.Version)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(reachedVersion.Path, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "This is version 004" {
+		t.Errorf("Expected the install at version 004, UT4.txt contains '%s'", contents)
+	}
+	latestVersion, err := plannerUpdater.GetLatestVersion()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if latestVersion.Path != reachedVersion.Path {
+		t.Errorf("Expected %s to be the latest version, got %s",
+			reachedVersion.Path,
+			latestVersion.Path)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,20 +16,35 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cavaliercoder/grab"
-	"github.com/elauqsap/workerpool"
 	"github.com/google/uuid"
-	"github.com/sethgrid/pester"
 )
 
+// UpdaterVersion is the semantic version of the updater, versions that
+// require a newer updater are not installed
+const UpdaterVersion = "1.0.0"
+
 const (
 	runVersionLatest = "latest"
+	// defaultDownloadIdleTimeout is the time a download may go
+	// without receiving data
+	defaultDownloadIdleTimeout = time.Minute
+)
+
+// The operations in a hash delta
+const (
+	operationAdded    = "added"
+	operationModified = "modified"
+	operationRemoved  = "removed"
 )
 
 // UT4Updater is the main executor for the updater
@@ -39,6 +56,25 @@ type UT4Updater struct {
 	updateURL    string
 	versionMaps  VersionMaps
 	clientID     string
+	publicKey    ed25519.PublicKey
+	// downloadTimeout limits the duration of a package download,
+	// 0 means no limit
+	downloadTimeout time.Duration
+	// downloadIdleTimeout stops a download that hasn't received
+	// data for the duration, 0 means no limit
+	downloadIdleTimeout time.Duration
+	// httpClient, userAgent and retryPolicy are used for every
+	// request to the update server
+	httpClient  *http.Client
+	userAgent   string
+	retryPolicy RetryPolicy
+	// forceRehash ignores the hash cache and hashes every file
+	forceRehash bool
+	// channel is the release channel updates are installed from
+	channel string
+	// fileDownloads downloads the files of an update one by one when
+	// the update server has no package for it
+	fileDownloads bool
 }
 
 // New creates aand initializes a new instance of UT4Updater
@@ -46,13 +82,27 @@ func New(installPath string,
 	keepVersions uint,
 	runVersion string,
 	sendStats bool,
-	updateURL string) (*UT4Updater, error) {
+	updateURL string,
+	options ...Option) (*UT4Updater, error) {
 	updater := &UT4Updater{
 		installPath:  installPath,
 		keepVersions: keepVersions,
 		runVersion:   runVersion,
 		sendStats:    sendStats,
 		updateURL:    updateURL,
+
+		downloadIdleTimeout: defaultDownloadIdleTimeout,
+		httpClient:          &http.Client{Timeout: defaultHTTPTimeout},
+		userAgent:           defaultUserAgent,
+		retryPolicy:         DefaultRetryPolicy,
+		channel:             ChannelStable,
+		fileDownloads:       true,
+	}
+	for _, option := range options {
+		err := option(updater)
+		if err != nil {
+			return updater, err
+		}
 	}
 	fullPath, err := filepath.Abs(updater.installPath)
 	if err != nil {
@@ -60,9 +110,14 @@ func New(installPath string,
 	}
 	updater.installPath = fullPath
 
-	err = updater.updateVersionMap()
+	err = updater.recoverStagedUpdates()
+	if err != nil {
+		return updater, fmt.Errorf("Unable to recover interrupted update: %w", err)
+	}
+
+	err = updater.updateVersionMap(context.Background())
 	if err != nil {
-		return updater, fmt.Errorf("Unable to update version map: %s", err.Error())
+		return updater, fmt.Errorf("Unable to update version map: %w", err)
 	}
 
 	// On the first run we generate a UUID for this client
@@ -84,36 +139,41 @@ func New(installPath string,
 }
 
 // updateVersionMap retrieves the version map from the update server
-// and saves a copy locally
-func (updater *UT4Updater) updateVersionMap() error {
+// and saves a copy locally. The local copy is only used when its
+// signature can be verified
+func (updater *UT4Updater) updateVersionMap(ctx context.Context) error {
+
+	versionMapResource := "update/ut4-versionmap"
+	versionMapPath := filepath.Join(updater.installPath, "versionmap.json")
+	signaturePath := versionMapPath + ".sig"
 
-	versionMapURL := fmt.Sprintf("%s/%s/%s",
-		updater.updateURL,
-		"update",
-		"ut4-versionmap")
-	var mapReader io.ReadCloser
-	response, err := http.Get(versionMapURL)
+	versionMapBytes, signature, err := updater.getSigned(ctx, versionMapResource)
+	if errors.Is(err, ErrInvalidSignature) {
+		// A tampered response is not the same as an unavailable server
+		return err
+	}
+	fromRemote := err == nil
 	if err != nil {
 		// We were unable to fetch the version map from the remote server
 		// now we can check if a local copy exists
-		// Declaring localErr to avoid shadowing mapReader
 		var localErr error
-		mapReader, localErr = os.Open(filepath.Join(
-			updater.installPath,
-			"versionmap.json"))
+		versionMapBytes, localErr = ioutil.ReadFile(versionMapPath)
+		if localErr == nil && updater.publicKey != nil {
+			var localSignature []byte
+			localSignature, localErr = ioutil.ReadFile(signaturePath)
+			if localErr == nil {
+				localErr = updater.verifySignature(
+					versionMapResource,
+					versionMapBytes,
+					string(localSignature))
+			}
+		}
 		if localErr != nil {
-			return fmt.Errorf("Remote returned '%s' and local copy returned '%s'",
+			return newError(ErrServerUnavailable, fmt.Errorf(
+				"Remote returned '%s' and local copy returned '%s'",
 				err.Error(),
-				localErr.Error())
+				localErr.Error()))
 		}
-	} else {
-		// Response received
-		mapReader = response.Body
-	}
-
-	versionMapBytes, err := ioutil.ReadAll(mapReader)
-	if err != nil {
-		return err
 	}
 
 	var versionMaps VersionMaps
@@ -121,27 +181,51 @@ func (updater *UT4Updater) updateVersionMap() error {
 	if err != nil {
 		return err
 	}
-	defer mapReader.Close()
 	updater.versionMaps = versionMaps
 
-	// Write a local cache for the versionmap
-	err = ioutil.WriteFile(filepath.Join(
-		updater.installPath,
-		"versionmap.json"), versionMapBytes, 0644)
+	if !fromRemote {
+		return nil
+	}
+	// Write a local cache for the versionmap, the signature is kept to
+	// verify the cache when the update server isn't available
+	err = ioutil.WriteFile(versionMapPath, versionMapBytes, 0644)
 	if err != nil {
 		return err
 	}
-
+	if signature != "" {
+		return ioutil.WriteFile(signaturePath, []byte(signature), 0644)
+	}
+	err = os.Remove(signaturePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
 // getFilelist returns the list of all the files (with full path) in the
-// specified path
-func (updater *UT4Updater) getFilelist(searchPath string) ([]string, error) {
+// specified path. Symbolic links are left out, they are created by the
+// update packages and aren't part of the hashes of a version. The walk
+// stops when ctx is cancelled
+func (updater *UT4Updater) getFilelist(
+	ctx context.Context,
+	searchPath string) ([]string, error) {
 	var fileList []string
 	err := filepath.Walk(
 		searchPath,
 		func(path string, fileInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// The updater's own files are not part of the version
+			if fileInfo.IsDir() && fileInfo.Name() == metadataDir {
+				return filepath.SkipDir
+			}
+			if fileInfo.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
 			if fileInfo.IsDir() == false {
 				fileList = append(fileList, path)
 			}
@@ -153,21 +237,17 @@ func (updater *UT4Updater) getFilelist(searchPath string) ([]string, error) {
 // getVersionManifest retrieves the filenames and hashes for the
 // specified version from the update server
 func (updater *UT4Updater) getRemoteVersionHashes(
+	ctx context.Context,
 	version string) (map[string]string, error) {
 
-	url := fmt.Sprintf("%s/%s/%s",
-		updater.updateURL,
-		"update/ut4-hash",
-		version)
-
-	response, err := http.Get(url)
+	body, _, err := updater.getSigned(ctx,
+		fmt.Sprintf("%s/%s", "update/ut4-hash", version))
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
 
 	var versionHashes map[string]string
-	err = json.NewDecoder(response.Body).Decode(&versionHashes)
+	err = json.Unmarshal(body, &versionHashes)
 	if err != nil {
 		return nil, err
 	}
@@ -187,28 +267,40 @@ func (updater *UT4Updater) calculateHashDeltaOperations(
 		if nextHash, ok := next[file]; ok {
 			if nextHash != hash {
 				// File has been modified
-				delta[file] = "modified"
+				delta[file] = operationModified
 			}
 		} else {
 			// File has been removed
-			delta[file] = "removed"
+			delta[file] = operationRemoved
 		}
 	}
 	for file := range next {
 		if _, ok := current[file]; !ok {
-			delta[file] = "added"
+			delta[file] = operationAdded
 		}
 	}
 	return delta
 }
 
-// generateDeltaHash generates a SHA256 hash for a map of files
-// and their update operations
+// deltaHashFormat is the first line of the data hashed by generateDeltaHash,
+// it changes whenever the format does
+const deltaHashFormat = "ut4-delta-v1"
+
+// generateDeltaHash generates the hash that identifies the update package
+// for the delta operations to the next version. It is the hex encoded
+// SHA256 of the format line followed by a line for every file, sorted
+// byte-wise by path:
+//
+//	ut4-delta-v1\n
+//	<operation>\t<target hash>\t<path>\n
+//
+// The target hash is the file's hash in nextHashes, empty for removed
+// files, and the path is slash separated and relative to the version
 func (updater *UT4Updater) generateDeltaHash(
-	deltaOperations map[string]string) string {
+	deltaOperations map[string]string,
+	nextHashes map[string]string) string {
 
-	hasher := sha256.New()
-	keys := make([]string, len(deltaOperations))
+	keys := make([]string, 0, len(deltaOperations))
 	for key := range deltaOperations {
 		keys = append(keys, key)
 	}
@@ -216,101 +308,220 @@ func (updater *UT4Updater) generateDeltaHash(
 	// order it to ensure the hashes are always the same for
 	// the same operations
 	sort.Strings(keys)
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\n", deltaHashFormat)
 	for _, key := range keys {
-		hasher.Write([]byte(deltaOperations[key]))
+		targetHash := ""
+		if deltaOperations[key] != operationRemoved {
+			targetHash = nextHashes[key]
+		}
+		fmt.Fprintf(hasher, "%s\t%s\t%s\n", deltaOperations[key], targetHash, key)
 	}
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
-// getUpdatePackageURL retrieves the download URL for the given package URL
-func (updater *UT4Updater) getUpdatePackageURL(
-	versionHash string) (string, error) {
-
-	url := fmt.Sprintf("%s/%s/%s",
-		updater.updateURL,
-		"update/ut4-update",
-		versionHash)
+// getUpdateCommand retrieves the update command with the download URL, size
+// and SHA256 hash of the package for the given delta hash
+func (updater *UT4Updater) getUpdateCommand(
+	ctx context.Context,
+	versionHash string) (UpdateCommand, error) {
 
-	response, err := http.Get(url)
+	body, _, err := updater.getSigned(ctx,
+		fmt.Sprintf("%s/%s", "update/ut4-update", versionHash))
 	if err != nil {
-		return "", err
+		return UpdateCommand{}, err
 	}
-	defer response.Body.Close()
 
-	var updateCommand map[string]string
-	err = json.NewDecoder(response.Body).Decode(&updateCommand)
+	var updateCommand UpdateCommand
+	err = json.Unmarshal(body, &updateCommand)
 	if err != nil {
-		return "", err
+		return UpdateCommand{}, err
+	}
+	if updateCommand.UpdateURL == "" {
+		return UpdateCommand{}, newError(ErrInvalidUpdateCommand,
+			errors.New("missing update URL"))
 	}
-	if _, ok := updateCommand["update_url"]; !ok {
-		return "", errors.New("Invalid update URL received")
+	if updateCommand.Size <= 0 {
+		return UpdateCommand{}, newError(ErrInvalidUpdateCommand,
+			errors.New("invalid package size"))
 	}
-	return updateCommand["update_url"], nil
+	if _, err := hex.DecodeString(updateCommand.SHA256); err != nil ||
+		len(updateCommand.SHA256) != sha256.Size*2 {
+		return UpdateCommand{}, newError(ErrInvalidUpdateCommand,
+			errors.New("invalid package SHA256"))
+	}
+	return updateCommand, nil
 }
 
-// downloadUpdate downloads the update given by getUpdatePackageURL and
-// returns true if downloaded successfully
+// downloadUpdate downloads the package given by getUpdateCommand and
+// returns true if downloaded successfully. A package that doesn't match the
+// expected SHA256, or the size when it's known, is removed and
+// ErrChecksumMismatch is returned.
+// The download is stopped when ctx is cancelled, when it takes longer than
+// downloadTimeout or when no data is received for downloadIdleTimeout,
+// waiting for the response headers counts as receiving no data
 func (updater *UT4Updater) downloadUpdate(
-	packageURL string,
+	ctx context.Context,
+	updateCommand UpdateCommand,
 	savePath string,
-	cancelChan chan bool,
 	feedbackChan chan DownloadProgressEvent) (bool, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Millisecond)
+	checksum, err := hex.DecodeString(updateCommand.SHA256)
+	if err != nil {
+		return false, err
+	}
+	// A previous download is resumed or reused when complete
+	complete, err := checkExistingDownload(savePath, updateCommand)
+	if err != nil {
+		return false, err
+	}
+	if complete {
+		if feedbackChan != nil {
+			feedbackChan <- DownloadProgressEvent{
+				Filename:  savePath,
+				Completed: true,
+				Percent:   100.00,
+			}
+		}
+		return true, nil
+	}
+
+	// The timeouts cancel the request so that they also cover the wait
+	// for the response headers, which includes the probe of a resume
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	client := grab.NewClient()
-	req, err := grab.NewRequest(savePath, packageURL)
+	var timedOut int32
+	timeoutAfter := func(duration time.Duration) *time.Timer {
+		return time.AfterFunc(duration, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+	}
+	// ctxErr returns the reason the request context was cancelled
+	ctxErr := func() error {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			return ErrDownloadTimeout
+		}
+		return ctx.Err()
+	}
+	if updater.downloadTimeout > 0 {
+		timer := timeoutAfter(updater.downloadTimeout)
+		defer timer.Stop()
+	}
+	var headerTimer *time.Timer
+	if updater.downloadIdleTimeout > 0 {
+		headerTimer = timeoutAfter(updater.downloadIdleTimeout)
+	}
+
+	client := updater.newDownloadClient()
+	req, err := grab.NewRequest(savePath, updateCommand.UpdateURL)
 	if err != nil {
 		return false, err
 	}
-	req.WithContext(ctx)
+	req = req.WithContext(ctx)
+	if updateCommand.Size > 0 {
+		req.Size = updateCommand.Size
+	}
+	req.SetChecksum(sha256.New(), checksum, true)
 
+	// Do returns once the response headers are received, or the
+	// connection failed
 	resp := client.Do(req)
-	if resp.HTTPResponse.StatusCode >= 300 {
-		return false,
-			fmt.Errorf("Received non 2XX status code: %s", resp.HTTPResponse.Status)
+	if headerTimer != nil {
+		headerTimer.Stop()
+	}
+	if ctx.Err() != nil {
+		<-resp.Done
+		return false, ctxErr()
+	}
+	if resp.HTTPResponse != nil && resp.HTTPResponse.StatusCode >= 300 {
+		cancel()
+		<-resp.Done
+		return false, &HTTPStatusError{
+			URL:        updateCommand.UpdateURL,
+			StatusCode: resp.HTTPResponse.StatusCode,
+			Status:     resp.HTTPResponse.Status,
+		}
+	}
+
+	sendProgress := func(completed bool) {
+		if feedbackChan == nil {
+			return
+		}
+		mbps, eta, percent := progressStats(resp)
+		if completed {
+			eta = 0
+		}
+		feedbackChan <- DownloadProgressEvent{
+			Filename:  resp.Filename,
+			Mbps:      mbps,
+			ETA:       eta,
+			Completed: completed,
+			Percent:   percent,
+		}
 	}
 
 	t := time.NewTicker(time.Second)
 	defer t.Stop()
+	var downloadErr error
+	lastBytesComplete := resp.BytesComplete()
+	lastActivity := time.Now()
 
 UpdateLoop:
 	for {
 		select {
 		case <-t.C:
 			// On every tick, send an update
-			feedbackChan <- DownloadProgressEvent{
-				Filename:  resp.Filename,
-				Mbps:      resp.BytesPerSecond() / 1024.00 / 1024.00,
-				ETA:       float64(resp.ETA().Second()),
-				Completed: false,
-				Percent:   resp.Progress() * 100.00,
+			sendProgress(false)
+			if resp.BytesComplete() != lastBytesComplete {
+				lastBytesComplete = resp.BytesComplete()
+				lastActivity = time.Now()
+			} else if updater.downloadIdleTimeout > 0 &&
+				time.Since(lastActivity) > updater.downloadIdleTimeout {
+				downloadErr = ErrDownloadTimeout
+				break UpdateLoop
 			}
 		case <-resp.Done:
-			feedbackChan <- DownloadProgressEvent{
-				Filename:  resp.Filename,
-				Mbps:      resp.BytesPerSecond() / 1024.00 / 1024.00,
-				ETA:       float64(resp.ETA().Second()),
-				Completed: true,
-				Percent:   resp.Progress() * 100.00,
-			}
+			sendProgress(true)
 			break UpdateLoop
-		case <-cancelChan:
-			cancel()
+		case <-ctx.Done():
+			downloadErr = ctxErr()
 			break UpdateLoop
 		}
 	}
+	if downloadErr != nil {
+		// Stop the transfer before returning
+		cancel()
+		<-resp.Done
+		return false, downloadErr
+	}
 	if err := resp.Err(); err != nil {
+		if ctx.Err() != nil {
+			return false, ctxErr()
+		}
+		if err == grab.ErrBadChecksum || err == grab.ErrBadLength {
+			os.Remove(savePath)
+			return false, ErrChecksumMismatch
+		}
+		if resp.HTTPResponse == nil {
+			// The connection failed before the headers were received
+			return false, newError(ErrServerUnavailable, err)
+		}
 		return false, err
 	}
 	return true, nil
 }
 
 // cloneLatestVersionTo copies the latest version to a new version folder
-// and returns the new base path of the installation
+// and returns the new base path of the installation. The bytes copied are
+// counted by tracker if not nil. When the copy fails or ctx is cancelled
+// the partial copy is removed
 func (updater *UT4Updater) cloneLatestVersionTo(
+	ctx context.Context,
 	version string,
-	overwrite bool) (string, error) {
+	overwrite bool,
+	tracker *ProgressTracker) (string, error) {
 	newInstallPath, err := updater.GetVersionPath(version, overwrite)
 	if err != nil {
 		err = os.RemoveAll(newInstallPath)
@@ -329,8 +540,9 @@ func (updater *UT4Updater) cloneLatestVersionTo(
 		// No installed version?
 		return "", err
 	}
-	err = CopyDir(latestVersion.Path, newInstallPath)
+	err = copyDir(ctx, latestVersion.Path, newInstallPath, tracker)
 	if err != nil {
+		_ = os.RemoveAll(newInstallPath)
 		return "", err
 	}
 	return newInstallPath, nil
@@ -343,13 +555,145 @@ func (updater *UT4Updater) GetVersionPath(
 	newInstallPath := filepath.Join(updater.installPath, version)
 	fileInfo, err := os.Stat(newInstallPath)
 	if err == nil && fileInfo.IsDir() && mustNotExist {
-		return newInstallPath, fmt.Errorf("The update path '%s' already exists", newInstallPath)
+		return newInstallPath, newError(ErrVersionExists,
+			fmt.Errorf("The update path '%s' already exists", newInstallPath))
 	}
 	return newInstallPath, nil
 }
 
-// applyUpdate applies the update from packagePAth into installPath
-func (updater *UT4Updater) applyUpdate(packagePath string, installPath string) error {
+// applyUpdate applies the update from packagePath into installPath.
+// Files removed in deltaOperations are deleted before the package is
+// extracted, each operation is reported to feedbackChan if not nil.
+// On any error, including ctx being cancelled, installPath is left as it was
+func (updater *UT4Updater) applyUpdate(
+	ctx context.Context,
+	packagePath string,
+	installPath string,
+	deltaOperations map[string]string,
+	feedbackChan chan ApplyProgressEvent) error {
+	return updater.applyStaged(
+		ctx,
+		installPath,
+		deltaOperations,
+		feedbackChan,
+		func(stagingPath string) error {
+			return updater.extractPackage(
+				ctx,
+				packagePath,
+				stagingPath,
+				deltaOperations,
+				feedbackChan)
+		})
+}
+
+// applyStaged applies an update to a staged copy of installPath which is
+// swapped into place once the whole update has been applied. The files
+// removed in deltaOperations are deleted before write adds the new and
+// modified files to the staged copy
+func (updater *UT4Updater) applyStaged(
+	ctx context.Context,
+	installPath string,
+	deltaOperations map[string]string,
+	feedbackChan chan ApplyProgressEvent,
+	write func(stagingPath string) error) error {
+	// Finish or undo a previous update that was interrupted
+	err := recoverStagedUpdate(installPath)
+	if err != nil {
+		return err
+	}
+
+	stagingPath, backupPath := stagingPaths(installPath)
+	err = os.RemoveAll(stagingPath)
+	if err != nil {
+		return err
+	}
+	// Linking the files makes the staged copy cheap, extracting replaces
+	// the links instead of writing through them
+	err = LinkDir(installPath, stagingPath)
+	if err != nil {
+		os.RemoveAll(stagingPath)
+		return err
+	}
+	err = updater.removeFiles(ctx, stagingPath, deltaOperations, feedbackChan)
+	if err != nil {
+		os.RemoveAll(stagingPath)
+		return err
+	}
+	err = write(stagingPath)
+	if err != nil {
+		os.RemoveAll(stagingPath)
+		return err
+	}
+
+	// Swap the staged update into place
+	err = os.Rename(installPath, backupPath)
+	if err != nil {
+		os.RemoveAll(stagingPath)
+		return err
+	}
+	err = os.Rename(stagingPath, installPath)
+	if err != nil {
+		// Roll back to the previous install
+		rollbackErr := os.Rename(backupPath, installPath)
+		if rollbackErr != nil {
+			return newError(ErrRollbackFailed, fmt.Errorf(
+				"Unable to apply update '%s' and rollback failed '%s'",
+				err.Error(),
+				rollbackErr.Error()))
+		}
+		os.RemoveAll(stagingPath)
+		return err
+	}
+	return os.RemoveAll(backupPath)
+}
+
+// removeFiles deletes the files removed in deltaOperations from installPath
+// along with the directories left empty
+func (updater *UT4Updater) removeFiles(
+	ctx context.Context,
+	installPath string,
+	deltaOperations map[string]string,
+	feedbackChan chan ApplyProgressEvent) error {
+
+	for file, operation := range deltaOperations {
+		if operation != operationRemoved {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		target, err := safeJoin(installPath, file)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Remove the directories that are now empty, os.Remove fails
+		// for directories that still contain files
+		for dir := filepath.Dir(target); dir != installPath; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+		if feedbackChan != nil {
+			feedbackChan <- ApplyProgressEvent{
+				Filepath:  file,
+				Operation: operation,
+			}
+		}
+	}
+	return nil
+}
+
+// extractPackage extracts the tar.gz package at packagePath into installPath
+func (updater *UT4Updater) extractPackage(
+	ctx context.Context,
+	packagePath string,
+	installPath string,
+	deltaOperations map[string]string,
+	feedbackChan chan ApplyProgressEvent) error {
 	packageFile, err := os.Open(packagePath)
 	if err != nil {
 		return err
@@ -358,106 +702,344 @@ func (updater *UT4Updater) applyUpdate(packagePath string, installPath string) e
 	// Start with the gz part of the tar.gz file
 	gzreader, err := gzip.NewReader(packageFile)
 	if err != nil {
-		return err
+		return newError(ErrInvalidPackage, err)
 	}
 
 	// Go through all files in tar archive
 	tarreader := tar.NewReader(gzreader)
 	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		header, err := tarreader.Next()
 		// No more
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return newError(ErrInvalidPackage, err)
+		}
+		// The root directory is the install path itself
+		if header.Typeflag == tar.TypeDir && isRootEntry(header.Name) {
+			continue
 		}
 		// get the filename in the archive
-		name := header.Name
-		target := filepath.Join(installPath, name)
+		name, err := cleanEntryName(header.Name)
+		if err != nil {
+			return err
+		}
+		target, err := safeJoin(installPath, name)
+		if err != nil {
+			return err
+		}
 		switch header.Typeflag {
 		case tar.TypeDir:
 			err = os.MkdirAll(target, 0755)
 			if err != nil {
 				return err
 			}
-			fmt.Println("New Dir: ", name)
 			continue
 		case tar.TypeReg:
-			newFile, err := os.OpenFile(
+			err = extractFile(
+				packageReader{ctx: ctx, reader: tarreader},
 				target,
-				os.O_CREATE|os.O_RDWR,
-				os.FileMode(header.Mode))
-			if err != nil {
-				return err
+				os.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			err = extractSymlink(installPath, target, name, header.Linkname)
+		case tar.TypeLink:
+			err = extractHardlink(installPath, target, name, header.Linkname)
+		default:
+			err = &UnsafeEntryError{
+				Name:   header.Name,
+				Reason: fmt.Sprintf("unsupported entry type '%c'", header.Typeflag),
 			}
-			defer newFile.Close()
-			// copy over contents
-			if _, err := io.Copy(newFile, tarreader); err != nil {
-				return err
+		}
+		if err != nil {
+			return err
+		}
+		if feedbackChan != nil {
+			operation, ok := deltaOperations[name]
+			if !ok {
+				operation = operationAdded
+			}
+			feedbackChan <- ApplyProgressEvent{
+				Filepath:  name,
+				Operation: operation,
 			}
-			newFile.Close()
 		}
 	}
-
-	// Everything was fine, clean up the downloaded package
-	os.Remove(packagePath)
 	return nil
 }
 
+// extractSymlink creates a symbolic link at target, the link must point
+// to a path inside installPath after following the existing links
+func extractSymlink(
+	installPath string,
+	target string,
+	name string,
+	linkname string) error {
+
+	if linkname == "" || filepath.IsAbs(linkname) || path.IsAbs(linkname) {
+		return &UnsafeEntryError{Name: name, Reason: "symbolic link must be relative"}
+	}
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	// The links already extracted are followed, a link to '..' in a
+	// directory that is itself a link to '..' leaves the install path
+	resolvedRoot, err := filepath.EvalSymlinks(installPath)
+	if err != nil {
+		return err
+	}
+	linkTarget, err := resolveLinkTarget(filepath.Dir(target), linkname)
+	if err != nil || !isWithin(resolvedRoot, linkTarget) {
+		return &UnsafeEntryError{
+			Name:   name,
+			Reason: "symbolic link points outside the install path"}
+	}
+	err = os.RemoveAll(target)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(linkname, target)
+}
+
+// extractHardlink creates a hard link at target to the file linkname in
+// installPath
+func extractHardlink(
+	installPath string,
+	target string,
+	name string,
+	linkname string) error {
+
+	source, err := safeJoin(installPath, linkname)
+	if err != nil {
+		return &UnsafeEntryError{
+			Name:   name,
+			Reason: "hard link points outside the install path"}
+	}
+	sourceInfo, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !sourceInfo.Mode().IsRegular() {
+		return &UnsafeEntryError{
+			Name:   name,
+			Reason: "hard link must point to a regular file"}
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// packageReader returns read errors from an update package
+// as ErrInvalidPackage and stops reading when ctx is cancelled
+type packageReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (packageReader packageReader) Read(data []byte) (int, error) {
+	if packageReader.ctx.Err() != nil {
+		return 0, packageReader.ctx.Err()
+	}
+	n, err := packageReader.reader.Read(data)
+	if err != nil && err != io.EOF {
+		err = newError(ErrInvalidPackage, err)
+	}
+	return n, err
+}
+
+// extractFile writes the contents of reader to a new file at target,
+// replacing the existing file
+func extractFile(reader io.Reader, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	// The target could be a link to the previous version's file
+	err = os.Remove(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	newFile, err := os.OpenFile(
+		target,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		mode)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+	// copy over contents
+	if _, err := io.Copy(newFile, reader); err != nil {
+		return err
+	}
+	// Make sure the contents are on disk before the staged
+	// update is swapped into place
+	err = newFile.Sync()
+	if err != nil {
+		return err
+	}
+	return newFile.Close()
+}
+
 // GenerateHashes generates SHA256 hashes for the given file list
-// and returns the file list with the file hash
+// and returns the file list with the file hash. Progress is sent to
+// updateFeedbackChan if it isn't nil, the channel is not closed.
+// Files that can't be hashed are returned as a *HashError, the hashes
+// of the other files are still returned
 func (updater *UT4Updater) GenerateHashes(
 	fileList []string,
 	maxHashers int,
 	updateFeedbackChan chan HashProgressEvent) (map[string]string, error) {
+	return updater.GenerateHashesContext(
+		context.Background(),
+		fileList,
+		maxHashers,
+		updateFeedbackChan)
+}
+
+// GenerateHashesContext is GenerateHashes with a context, files that haven't
+// been hashed when ctx is cancelled are skipped and ctx.Err() is returned
+func (updater *UT4Updater) GenerateHashesContext(
+	ctx context.Context,
+	fileList []string,
+	maxHashers int,
+	updateFeedbackChan chan HashProgressEvent) (map[string]string, error) {
 
 	hashes := make(map[string]string)
+	if len(fileList) == 0 {
+		return hashes, nil
+	}
 	internalFeedbackChan := make(chan HashProgressEvent)
-	dispatcher := workerpool.NewDispatcher(maxHashers, 1024)
-	dispatcher.Run()
-	// Add the jobs to the waitgroup
-	dispatcher.WaitGroup.Add(len(fileList))
-	// Queue jobs!
-	for _, filepath := range fileList {
-		dispatcher.JobQueue <- HashJob{filepath: filepath, progress: internalFeedbackChan}
+	jobs := make([]job, len(fileList))
+	for i, filepath := range fileList {
+		jobs[i] = HashJob{
+			ctx:      ctx,
+			filepath: filepath,
+			progress: internalFeedbackChan,
+		}
 	}
+	workers := runJobs(maxHashers, jobs)
 
-	for feedback := range internalFeedbackChan {
+	// Every job finishes with either a completed or an error event
+	failed := make(map[string]string)
+	for finished := 0; finished < len(fileList); {
+		feedback := <-internalFeedbackChan
 		if feedback.Completed {
 			hashes[feedback.Filepath] = feedback.Hash
-			if len(hashes) == len(fileList) {
-				close(internalFeedbackChan)
-			}
+			finished++
+		} else if feedback.Error != "" {
+			failed[feedback.Filepath] = feedback.Error
+			finished++
+		}
+		if updateFeedbackChan != nil {
+			updateFeedbackChan <- feedback
 		}
-		updateFeedbackChan <- feedback
 	}
-	// Block until all the jobs complete
-	dispatcher.WaitGroup.Wait()
-	close(updateFeedbackChan)
+	// Block until all the workers exit
+	workers.Wait()
+	if ctx.Err() != nil {
+		return hashes, ctx.Err()
+	}
+	if len(failed) > 0 {
+		return hashes, &HashError{Files: failed}
+	}
 	return hashes, nil
 }
 
-// GetLatestVersion returns the latest version installed
+// GetVersionMaps returns the version maps of every release channel as last
+// retrieved from the update server, the VersionMaps helpers query them
+func (updater *UT4Updater) GetVersionMaps() VersionMaps {
+	return append(VersionMaps(nil), updater.versionMaps...)
+}
+
+// GetLatestVersion returns the latest installed version of the release
+// channel. If no version of the channel is installed the latest installed
+// version is returned, updates to the channel start from it
 func (updater *UT4Updater) GetLatestVersion() (UT4Version, error) {
 	versions, err := updater.GetVersionList()
 	if err != nil {
 		return UT4Version{}, err
 	}
 	if len(versions) == 0 {
-		return UT4Version{}, errors.New("No Unreal Tournament versions installed")
+		return UT4Version{}, ErrNoInstalledVersion
+	}
+	for _, version := range versions {
+		if version.ReleaseChannel() == updater.channel {
+			return version, nil
+		}
 	}
 	return versions[0], nil
 }
 
-// GetVersionList returns the available installed versions as [version][path]
+// GetChannel returns the release channel updates are installed from
+func (updater *UT4Updater) GetChannel() string {
+	return updater.channel
+}
+
+// SetChannel switches the release channel. The next update installs the
+// latest version of the channel, even when it's older than the installed
+// versions which is how a preview channel is left. This must not be
+// called while updating
+func (updater *UT4Updater) SetChannel(channel string) error {
+	channel = strings.TrimSpace(channel)
+	if channel == "" {
+		return newError(ErrInvalidConfig,
+			errors.New("The release channel must not be blank"))
+	}
+	updater.channel = channel
+	return nil
+}
+
+// GetChannelVersionMaps returns the version maps of the release channel
+func (updater *UT4Updater) GetChannelVersionMaps() VersionMaps {
+	return updater.versionMaps.FilterByChannel(updater.channel)
+}
+
+// GetRunVersion returns the installed version to run. If runVersion is
+// latest the latest installed version is returned, otherwise the installed
+// version matching the build version or semver
+func (updater *UT4Updater) GetRunVersion() (UT4Version, error) {
+	if updater.runVersion == "" || updater.runVersion == runVersionLatest {
+		return updater.GetLatestVersion()
+	}
+	versions, err := updater.GetVersionList()
+	if err != nil {
+		return UT4Version{}, err
+	}
+	for _, version := range versions {
+		if updater.isPinnedVersion(version) {
+			return version, nil
+		}
+	}
+	return UT4Version{}, newError(ErrVersionNotInstalled,
+		fmt.Errorf("The version '%s' to run is not installed", updater.runVersion))
+}
+
+// GetVersionList returns the installed versions with the latest first.
+// Directories that aren't in the version map and don't have a manifest
+// are not versions and are ignored
 func (updater *UT4Updater) GetVersionList() ([]UT4Version, error) {
+	return updater.GetVersionListContext(context.Background())
+}
+
+// GetVersionListContext is GetVersionList with a context
+func (updater *UT4Updater) GetVersionListContext(
+	ctx context.Context) ([]UT4Version, error) {
 	fileInfo, err := os.Stat(updater.installPath)
 	if err != nil {
 		return nil, err
 	}
 	if fileInfo.IsDir() == false {
-		return nil, errors.New("The install path must be a directory")
+		return nil, ErrInvalidInstallPath
 	}
 
 	files, err := ioutil.ReadDir(updater.installPath)
@@ -467,7 +1049,11 @@ func (updater *UT4Updater) GetVersionList() ([]UT4Version, error) {
 
 	var versions []UT4Version
 	for _, file := range files {
-		if file.IsDir() {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Hidden directories are used for staging updates
+		if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
 			versionPath, err := updater.GetVersionPath(file.Name(), false)
 			if err != nil {
 				continue
@@ -477,16 +1063,40 @@ func (updater *UT4Updater) GetVersionList() ([]UT4Version, error) {
 				VersionMap: updater.versionMaps.GetVersionMapByVersionNumber(
 					file.Name()),
 			}
+			manifest, err := readManifest(versionPath)
+			if err == nil {
+				version.Manifest = manifest
+			}
+			// Versions installed before the version map knows about them
+			// are identified by their manifest, other directories
+			// aren't versions
+			if version.Version == "" {
+				if manifest == nil {
+					continue
+				}
+				version.Version = file.Name()
+				version.SemVer = manifest.SemVer
+			}
+			if version.Channel == "" && manifest != nil {
+				version.Channel = manifest.Channel
+			}
 			versions = append(versions, version)
 		}
 	}
-	// Reverse the order so that the latest is at the top
+	// The latest version is at the top
 	sort.Sort(ByVersion(versions))
 	return versions, nil
 }
 
 // CheckForUpdate checks if an update is available
 func (updater *UT4Updater) CheckForUpdate() (bool, string, error) {
+	return updater.CheckForUpdateContext(context.Background())
+}
+
+// CheckForUpdateContext is CheckForUpdate with a context for the request
+// to the update server
+func (updater *UT4Updater) CheckForUpdateContext(
+	ctx context.Context) (bool, string, error) {
 	latestVersion, err := updater.GetLatestVersion()
 	if err != nil {
 		return false, "", err
@@ -501,7 +1111,7 @@ func (updater *UT4Updater) CheckForUpdate() (bool, string, error) {
 	var versions []string
 	if updater.sendStats {
 		osDistribution = updater.GetOSDistribution()
-		installedVersions, err := updater.GetVersionList()
+		installedVersions, err := updater.GetVersionListContext(ctx)
 		if err == nil {
 			for _, version := range installedVersions {
 				versions = append(versions, version.Version)
@@ -513,23 +1123,22 @@ func (updater *UT4Updater) CheckForUpdate() (bool, string, error) {
 		OS:             osDistribution,
 		Versions:       versions,
 		CurrentVersion: latestVersion.Version,
+		Channel:        updater.channel,
 	}
 	checkJSON, err := json.Marshal(updateCheckRequest)
 	if err != nil {
 		return false, "", err
 	}
 
-	client := pester.New()
-	client.Concurrency = 1
-	client.MaxRetries = 1
-	client.Backoff = pester.DefaultBackoff
 	req, err := http.NewRequest("POST",
 		fmt.Sprintf("%s/%s/%s", updater.updateURL, "update", "ut4-check"),
 		bytes.NewReader(checkJSON))
 	if err != nil {
 		return false, "", err
 	}
-	resp, err := client.Do(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := updater.doRequest(req)
 	if err != nil {
 		return false, "", err
 	}
@@ -550,17 +1159,614 @@ func (updater *UT4Updater) CheckForUpdate() (bool, string, error) {
 // updates.
 // This is safe to run in a goroutine.
 func (updater *UT4Updater) Update(feedback chan []byte) (UT4Version, error) {
+	return updater.UpdateContext(context.Background(), feedback)
+}
+
+// UpdateContext is Update with a context. Cancelling ctx stops the update,
+// the installed versions are left as they were. An update in place through
+// several releases that fails part way leaves the release it reached, which
+// is returned with the error
+func (updater *UT4Updater) UpdateContext(
+	ctx context.Context,
+	feedback chan []byte) (UT4Version, error) {
+	latestVersion, err := updater.GetLatestVersion()
+	if err != nil {
+		return UT4Version{}, updater.failUpdate(feedback, "", err)
+	}
+
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:  UpdateStatusChecking,
+		Version: latestVersion.Version,
+		Message: "Checking for updates",
+	})
+	updateAvailable, nextVersion, err := updater.CheckForUpdateContext(ctx)
+	if err != nil {
+		return latestVersion, updater.failUpdate(feedback, latestVersion.Version, err)
+	}
+	if !updateAvailable || nextVersion == latestVersion.Version {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:    UpdateStatusCompleted,
+			Version:   latestVersion.Version,
+			Message:   "Already up to date",
+			Percent:   100.00,
+			Completed: true,
+		})
+		return latestVersion, nil
+	}
+	// The version map is refreshed to check that the next version may be
+	// installed, the current version map is used if it can't be
+	_ = updater.updateVersionMap(ctx)
+	err = updater.checkInstallable(nextVersion)
+	if err != nil {
+		return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+	}
+
+	// Generate the hashes for the current install and determine
+	// what needs to change to get to the next version
+	currentHashes, err := updater.hashInstall(
+		ctx,
+		latestVersion.Path,
+		updater.forceRehash,
+		nextVersion,
+		feedback)
+	if err != nil {
+		return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+	}
+	// Versions that weren't installed by the updater get a manifest
+	// now that their files are hashed
+	if latestVersion.Manifest == nil {
+		latestVersion.Manifest, _ = writeManifest(
+			latestVersion.Path,
+			latestVersion.VersionMap,
+			ManifestSourceLocal,
+			currentHashes)
+	}
+	nextHashes, err := updater.getRemoteVersionHashes(ctx, nextVersion)
+	if err != nil {
+		return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+	}
+	// Pick the packages to download, a single delta, a delta for every
+	// release in between or the full version
+	plan, err := updater.planUpdate(
+		ctx,
+		latestVersion.Version,
+		currentHashes,
+		nextVersion,
+		nextHashes)
+	if err != nil {
+		return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+	}
+	message := fmt.Sprintf("Downloading %d packages of the %s update, %d bytes",
+		len(plan.steps),
+		plan.kind,
+		plan.size())
+	if plan.kind == UpdatePlanFiles {
+		message = fmt.Sprintf("Downloading the %s update file by file", plan.kind)
+	}
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:  UpdateStatusDownloading,
+		Version: nextVersion,
+		Message: message,
+	})
+	// A files plan is downloaded to a directory instead of a package
+	packagePaths := make([]string, len(plan.steps))
+	for i, step := range plan.steps {
+		if plan.kind == UpdatePlanFiles {
+			packagePaths[i] = updater.getFileCachePath(
+				updater.generateDeltaHash(step.operations, step.hashes))
+			continue
+		}
+		packagePaths[i], err = updater.getPackageCachePath(step.command)
+		if err != nil {
+			return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+		}
+	}
+	// Partial downloads for other packages will never be resumed
+	err = updater.cleanPackageCache(packagePaths...)
+	if err != nil {
+		return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+	}
+	// The packages are kept until they are applied so a failed download
+	// can be resumed
+	for i, step := range plan.steps {
+		if plan.kind == UpdatePlanFiles {
+			err = updater.downloadFiles(
+				ctx,
+				packagePaths[i],
+				step.operations,
+				step.hashes,
+				step.toVersion,
+				feedback)
+		} else {
+			err = updater.downloadPackage(
+				ctx,
+				step.command,
+				packagePaths[i],
+				step.toVersion,
+				feedback)
+		}
+		if err != nil {
+			return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+		}
+	}
+
+	// Keeping 0 versions means the update is applied to the current version,
+	// unless the current version is pinned to run
+	inPlace := updater.keepVersions == 0 && !updater.isPinnedVersion(latestVersion)
+	installPath := latestVersion.Path
+	if inPlace {
+		// The install is renamed to every version it's updated to,
+		// make sure that's possible before changing anything
+		for _, step := range plan.steps {
+			_, err = updater.GetVersionPath(step.toVersion, true)
+			if err != nil {
+				return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+			}
+		}
+	} else {
+		// Clone the current version and apply the update to the clone only
+		installPath, err = updater.cloneVersion(
+			ctx,
+			latestVersion,
+			nextVersion,
+			feedback)
+		if err != nil {
+			return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+		}
+	}
+	source := ManifestSourceUpdate
+	if inPlace {
+		source = ManifestSourceInPlaceUpdate
+	}
+	// An in place chain that fails after its first step leaves the install
+	// at the last version it reached, that version is returned instead
+	stepsCompleted := 0
+	failStep := func(err error) (UT4Version, error) {
+		if stepsCompleted > 0 {
+			err = fmt.Errorf("The update stopped at version %s: %w",
+				latestVersion.Version,
+				err)
+		}
+		return latestVersion, updater.failUpdate(feedback, nextVersion, err)
+	}
+	for i, step := range plan.steps {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:  UpdateStatusApplying,
+			Version: step.toVersion,
+			Message: fmt.Sprintf("Applying %d changes", len(step.operations)),
+		})
+		if plan.kind == UpdatePlanFiles {
+			err = updater.applyFiles(
+				ctx,
+				packagePaths[i],
+				installPath,
+				step.operations,
+				step.hashes,
+				step.modes,
+				step.toVersion,
+				feedback)
+		} else {
+			err = updater.applyPackage(
+				ctx,
+				packagePaths[i],
+				installPath,
+				step.operations,
+				step.toVersion,
+				feedback)
+		}
+		if err != nil {
+			if !inPlace {
+				// Don't leave a half updated version lying around
+				os.RemoveAll(installPath)
+			}
+			return failStep(err)
+		}
+		if !inPlace {
+			continue
+		}
+		// Every step leaves a complete version when updating in place
+		stepPath, err := updater.GetVersionPath(step.toVersion, true)
+		if err != nil {
+			return failStep(err)
+		}
+		err = os.Rename(installPath, stepPath)
+		if err != nil {
+			return failStep(err)
+		}
+		installPath = stepPath
+		if step.toVersion != nextVersion {
+			latestVersion, _ = updater.describeVersion(
+				installPath,
+				step.toVersion,
+				source,
+				step.hashes)
+			stepsCompleted++
+			updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+				Status:  UpdateStatusApplying,
+				Version: nextVersion,
+				Message: fmt.Sprintf("Updated to version %s", step.toVersion),
+			})
+		}
+	}
+
+	// The packages are only removed once applied so a failed update
+	// doesn't download them again
+	for _, packagePath := range packagePaths {
+		_ = os.RemoveAll(packagePath)
+	}
+
+	// The new version should now be in the version map, a failure here
+	// only means we won't have the semver and release date
+	_ = updater.updateVersionMap(ctx)
+	newVersion, err := updater.describeVersion(
+		installPath,
+		nextVersion,
+		source,
+		nextHashes)
+	if err != nil {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:  UpdateStatusApplying,
+			Version: nextVersion,
+			Error:   err.Error(),
+		})
+	}
+
+	// The update succeeded, failing to remove old versions is only reported
+	removedVersions, err := updater.pruneVersions()
+	for _, removedVersion := range removedVersions {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:  UpdateStatusPruning,
+			Version: nextVersion,
+			Message: fmt.Sprintf("Removed version %s", removedVersion.Version),
+		})
+	}
+	if err != nil {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:  UpdateStatusPruning,
+			Version: nextVersion,
+			Error:   err.Error(),
+		})
+	}
+
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:    UpdateStatusCompleted,
+		Version:   nextVersion,
+		Message:   fmt.Sprintf("Updated to version %s", nextVersion),
+		Percent:   100.00,
+		Completed: true,
+	})
+	return newVersion, nil
+}
+
+// describeVersion stores the known hashes and writes the manifest of the
+// version installed to installPath. Failing to store the hashes only means
+// the version is hashed on the next update, the version is returned even
+// when the manifest can't be written
+func (updater *UT4Updater) describeVersion(
+	installPath string,
+	version string,
+	source string,
+	hashes map[string]string) (UT4Version, error) {
+	_ = seedHashCache(installPath, hashes)
+
+	installedVersion := UT4Version{
+		Path:       installPath,
+		VersionMap: updater.versionMaps.GetVersionMapByVersionNumber(version),
+	}
+	installedVersion.Version = version
+	// The update server returned the version for the channel
+	if installedVersion.Channel == "" {
+		installedVersion.Channel = updater.channel
+	}
+	manifest, err := writeManifest(
+		installPath,
+		installedVersion.VersionMap,
+		source,
+		hashes)
+	installedVersion.Manifest = manifest
+	return installedVersion, err
+}
+
+// checkInstallable returns an error if the version map marks the version
+// as yanked or as requiring a newer updater
+func (updater *UT4Updater) checkInstallable(version string) error {
+	versionMap := updater.versionMaps.GetVersionMapByVersionNumber(version)
+	if versionMap.Yanked {
+		return newError(ErrVersionYanked,
+			fmt.Errorf("Version '%s' has been yanked", version))
+	}
+	if versionMap.RequiresNewerUpdater() {
+		return newError(ErrUpdaterOutdated,
+			fmt.Errorf("Version '%s' requires updater %s or newer",
+				version,
+				versionMap.MinUpdaterVersion))
+	}
+	return nil
+}
 
-	// Generate file list with hashes
-	// Generate JSON  file list with hashes
-	// Generate SHA256 hash for the filelist
-	// Generate update manifest
-	// Submit the update manifest
-	// Clone the current latest version with the new latest version name
-	// Download the updated files (wait for package)
-	// Apply (remove, add, update) the update
-	//
-	return UT4Version{}, nil
+// pruneVersions removes the installed versions beyond keepVersions, using
+// the GetVersionList ordering. The version pinned by runVersion, the latest
+// version of the release channel and directories not in the version map
+// are never removed
+func (updater *UT4Updater) pruneVersions() ([]UT4Version, error) {
+	versions, err := updater.GetVersionList()
+	if err != nil {
+		return nil, err
+	}
+	latestVersion, err := updater.GetLatestVersion()
+	if err != nil {
+		return nil, err
+	}
+	// Keeping 0 versions updates in place, which still leaves the
+	// current version
+	keep := int(updater.keepVersions)
+	if keep == 0 {
+		keep = 1
+	}
+
+	var removedVersions []UT4Version
+	for i, version := range versions {
+		if i < keep ||
+			updater.isPinnedVersion(version) ||
+			version.Path == latestVersion.Path {
+			continue
+		}
+		if updater.versionMaps.GetVersionMapByVersionNumber(
+			version.Version).Version == "" {
+			continue
+		}
+		err = os.RemoveAll(version.Path)
+		if err != nil {
+			return removedVersions, err
+		}
+		removedVersions = append(removedVersions, version)
+	}
+	return removedVersions, nil
+}
+
+// isPinnedVersion returns true if runVersion pins the given version
+func (updater *UT4Updater) isPinnedVersion(version UT4Version) bool {
+	if updater.runVersion == "" || updater.runVersion == runVersionLatest {
+		return false
+	}
+	return updater.runVersion == version.Version ||
+		updater.runVersion == version.SemVer
+}
+
+// cloneVersion clones latestVersion to nextVersion while reporting the
+// progress of the copy to feedback, the clone stops when ctx is cancelled
+func (updater *UT4Updater) cloneVersion(
+	ctx context.Context,
+	latestVersion UT4Version,
+	nextVersion string,
+	feedback chan []byte) (string, error) {
+
+	message := fmt.Sprintf("Cloning version %s", latestVersion.Version)
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:  UpdateStatusCloning,
+		Version: nextVersion,
+		Message: message,
+	})
+	size, err := DirSize(latestVersion.Path)
+	if err != nil {
+		return "", err
+	}
+	tracker := NewProgressTracker(size)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				mbps, eta, percent := progressStats(tracker)
+				updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+					Status:  UpdateStatusCloning,
+					Version: nextVersion,
+					Message: message,
+					Mbps:    mbps,
+					ETA:     eta,
+					Percent: percent,
+				})
+			case <-tracker.Done():
+				return
+			}
+		}
+	}()
+	newInstallPath, err := updater.cloneLatestVersionTo(
+		ctx,
+		nextVersion,
+		true,
+		tracker)
+	tracker.Finish(err)
+	<-done
+	return newInstallPath, err
+}
+
+// hashInstall generates the hashes for all files in installPath, keyed by
+// their slash separated path relative to installPath. Files that haven't
+// changed since they were last hashed are taken from the hash cache
+// unless force is set
+func (updater *UT4Updater) hashInstall(
+	ctx context.Context,
+	installPath string,
+	force bool,
+	nextVersion string,
+	feedback chan []byte) (map[string]string, error) {
+
+	fileList, err := updater.getFilelist(ctx, installPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := loadHashCache(installPath)
+	if force {
+		cache = newHashCache()
+	}
+	relativeHashes := make(map[string]string)
+	relativePaths := make(map[string]string)
+	fileInfos := make(map[string]os.FileInfo)
+	var hashList []string
+	for _, path := range fileList {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		relativePath, err := filepath.Rel(installPath, path)
+		if err != nil {
+			return nil, err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		relativePaths[path] = relativePath
+		fileInfos[path] = fileInfo
+		if hash, ok := cache.lookup(relativePath, fileInfo); ok {
+			relativeHashes[relativePath] = hash
+			continue
+		}
+		hashList = append(hashList, path)
+	}
+
+	hashFeedbackChan := make(chan HashProgressEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		completed := len(relativeHashes)
+		for event := range hashFeedbackChan {
+			if !event.Completed {
+				continue
+			}
+			completed++
+			updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+				Status:  UpdateStatusHashing,
+				Version: nextVersion,
+				Message: event.Filename,
+				Percent: float64(completed) / float64(len(fileList)) * 100.00,
+			})
+		}
+	}()
+	hashes, err := updater.GenerateHashesContext(
+		ctx,
+		hashList,
+		runtime.NumCPU(),
+		hashFeedbackChan)
+	close(hashFeedbackChan)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+
+	for path, hash := range hashes {
+		relativeHashes[relativePaths[path]] = hash
+	}
+	// Only the current files are kept in the cache
+	newCache := newHashCache()
+	for path, relativePath := range relativePaths {
+		newCache.add(relativePath, fileInfos[path], relativeHashes[relativePath])
+	}
+	// Failing to store the cache only means the files are hashed again
+	_ = newCache.save(installPath)
+	return relativeHashes, nil
+}
+
+// downloadPackage downloads the update package to packagePath while
+// reporting progress to feedback
+func (updater *UT4Updater) downloadPackage(
+	ctx context.Context,
+	updateCommand UpdateCommand,
+	packagePath string,
+	nextVersion string,
+	feedback chan []byte) error {
+
+	downloadFeedbackChan := make(chan DownloadProgressEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range downloadFeedbackChan {
+			updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+				Status:  UpdateStatusDownloading,
+				Version: nextVersion,
+				Message: event.Filename,
+				Mbps:    event.Mbps,
+				ETA:     event.ETA,
+				Percent: event.Percent,
+			})
+		}
+	}()
+	_, err := updater.downloadUpdate(
+		ctx,
+		updateCommand,
+		packagePath,
+		downloadFeedbackChan)
+	close(downloadFeedbackChan)
+	<-done
+	return err
+}
+
+// applyPackage applies the update package to installPath while reporting
+// every file operation to feedback
+func (updater *UT4Updater) applyPackage(
+	ctx context.Context,
+	packagePath string,
+	installPath string,
+	deltaOperations map[string]string,
+	nextVersion string,
+	feedback chan []byte) error {
+
+	applyFeedbackChan := make(chan ApplyProgressEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range applyFeedbackChan {
+			updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+				Status:  UpdateStatusApplying,
+				Version: nextVersion,
+				Message: fmt.Sprintf("%s %s", event.Operation, event.Filepath),
+			})
+		}
+	}()
+	err := updater.applyUpdate(
+		ctx,
+		packagePath,
+		installPath,
+		deltaOperations,
+		applyFeedbackChan)
+	close(applyFeedbackChan)
+	<-done
+	return err
+}
+
+// sendUpdateFeedback sends the event as JSON on the feedback channel,
+// a nil feedback channel is ignored
+func (updater *UT4Updater) sendUpdateFeedback(
+	feedback chan []byte,
+	event UpdateProgressEvent) {
+	if feedback == nil {
+		return
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	feedback <- eventJSON
+}
+
+// failUpdate reports the failed update on the feedback channel and returns
+// the original error
+func (updater *UT4Updater) failUpdate(
+	feedback chan []byte,
+	version string,
+	err error) error {
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:    UpdateStatusFailed,
+		Version:   version,
+		Error:     err.Error(),
+		Completed: true,
+	})
+	return err
 }
 
 // GetOSDistribution retrieves the kernel and distribution versions
@@ -1,18 +1,30 @@
 package ut4updater
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 var updater *UT4Updater
 
+// packageRangeRequests receives the Range header of every package download,
+// the HEAD requests that probe a resume aren't downloads and are left out
+var packageRangeRequests = make(chan string, 100)
+
 func TestMain(m *testing.M) {
 	var err error
 	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,26 +36,53 @@ func TestMain(m *testing.M) {
 			}
 			w.Write(versionMap)
 		} else if r.URL.EscapedPath() == "/update/ut4-check" {
+			var request UpdateCheckRequest
+			err = json.NewDecoder(r.Body).Decode(&request)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
 			response := UpdateCheckResponse{
 				LatestVersion:   "004",
 				UpdateAvailable: true,
 			}
+			// The preview channel is a version ahead
+			if request.Channel == ChannelPreview {
+				response.LatestVersion = "005"
+			}
 			err = json.NewEncoder(w).Encode(response)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 		} else if r.URL.EscapedPath() == "/update/ut4-hash/latest" {
 			w.Write([]byte("{\"Unreal.pak\": \"1234567890oiuytrewq\"}"))
-		} else if r.URL.EscapedPath() == "/update/ut4-update/deb3e700df1e6b29df98c26cc388417072b0bb5eeda3de7d035e186c315f161c" {
-			w.Write([]byte(fmt.Sprintf("{\"update_url\": \"http://%s/package.tar.gz\"}", r.Host)))
-		} else if r.URL.EscapedPath() == "/package.tar.gz" {
+		} else if r.URL.EscapedPath() == "/update/ut4-hash/004" {
+			w.Write([]byte("{\"UT4.txt\": \"dc4130892be21685aa1fa38448c02306a1a521489ecb8757732ad391714e8c16\", \".gitkeep\": \"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\"}"))
+		} else if strings.HasPrefix(r.URL.EscapedPath(), "/update/ut4-update/") {
 			packageBytes, err := ioutil.ReadFile("./test-resources/packages/package.tar.gz")
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+			json.NewEncoder(w).Encode(UpdateCommand{
+				UpdateURL: fmt.Sprintf("http://%s/package.tar.gz", r.Host),
+				Size:      int64(len(packageBytes)),
+				SHA256:    fmt.Sprintf("%x", sha256.Sum256(packageBytes)),
+			})
+		} else if r.URL.EscapedPath() == "/package.tar.gz" {
+			if r.Method == http.MethodGet {
+				packageRangeRequests <- r.Header.Get("Range")
+			}
+			packageFile, err := os.Open("./test-resources/packages/package.tar.gz")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer packageFile.Close()
 			w.Header().Add("Content-Type", "application/gzip")
-			w.Write(packageBytes)
+			http.ServeContent(w, r, "package.tar.gz", time.Time{}, packageFile)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
 		}
 		//fmt.Println(r.URL.EscapedPath())
 	}))
@@ -71,6 +110,37 @@ func TestGetVersionList(t *testing.T) {
 	}
 }
 
+func TestGetVersionListOrdering(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	previousVersionMaps := updater.versionMaps
+	defer func() { updater.versionMaps = previousVersionMaps }()
+	updater.versionMaps = append(VersionMaps{
+		{Version: "9", SemVer: "0.9.0"},
+		{Version: "10", SemVer: "0.10.0"},
+	}, previousVersionMaps...)
+	for _, dir := range []string{"9", "10", "tmp"} {
+		err := os.Mkdir(filepath.Join(installPath, dir), 0755)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	versions, err := updater.GetVersionList()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []string{"10", "9", "003", "002", "001"}
+	if len(versions) != len(expected) {
+		t.Fatalf("Expected %d versions, got %v", len(expected), versions)
+	}
+	for i, version := range versions {
+		if version.Version != expected[i] {
+			t.Errorf("Expected version %s at %d, got %s", expected[i], i, version.Version)
+		}
+	}
+}
+
 func TestGetLatestVersion(t *testing.T) {
 	latestVersion, err := updater.GetLatestVersion()
 	if err != nil {
@@ -83,6 +153,154 @@ func TestGetLatestVersion(t *testing.T) {
 	}
 }
 
+func TestGetRunVersion(t *testing.T) {
+	previousRun := updater.runVersion
+	defer func() { updater.runVersion = previousRun }()
+
+	tests := map[string]string{
+		"latest": "003",
+		"002":    "002",
+		"0.0.1":  "001",
+	}
+	for runVersion, expected := range tests {
+		updater.runVersion = runVersion
+		version, err := updater.GetRunVersion()
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if version.Version != expected {
+			t.Errorf("Returned version '%s' for '%s'. Expected '%s'",
+				version.Version,
+				runVersion,
+				expected)
+		}
+	}
+
+	updater.runVersion = "999"
+	_, err := updater.GetRunVersion()
+	if err == nil {
+		t.Error("A version that is not installed must return an error")
+	}
+}
+
+func TestChannels(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	previousKeep := updater.keepVersions
+	defer func() {
+		updater.keepVersions = previousKeep
+		updater.SetChannel(ChannelStable)
+	}()
+	// A newer preview build is installed next to the stable builds
+	previewPath := filepath.Join(installPath, "900")
+	err := os.Mkdir(previewPath, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = writeManifest(
+		previewPath,
+		VersionMap{Version: "900", Channel: ChannelPreview},
+		ManifestSourceUpdate,
+		nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	latestVersion, err := updater.GetLatestVersion()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if latestVersion.Version != "003" {
+		t.Errorf("Expected the latest stable version 003, got '%s'", latestVersion.Version)
+	}
+	_, nextVersion, err := updater.CheckForUpdate()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if nextVersion != "004" {
+		t.Errorf("Expected stable version 004, got '%s'", nextVersion)
+	}
+
+	err = updater.SetChannel(ChannelPreview)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	latestVersion, err = updater.GetLatestVersion()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if latestVersion.Version != "900" {
+		t.Errorf("Expected the latest preview version 900, got '%s'", latestVersion.Version)
+	}
+	_, nextVersion, err = updater.CheckForUpdate()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if nextVersion != "005" {
+		t.Errorf("Expected preview version 005, got '%s'", nextVersion)
+	}
+
+	// Switching back to stable must never prune the latest stable version
+	updater.SetChannel(ChannelStable)
+	updater.keepVersions = 1
+	removedVersions, err := updater.pruneVersions()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(removedVersions) != 2 {
+		t.Errorf("Expected versions 002 and 001 to be removed, got %v", removedVersions)
+	}
+	if _, err := os.Stat(filepath.Join(installPath, "003")); err != nil {
+		t.Error("The latest stable version must not be pruned")
+	}
+
+	if err := updater.SetChannel(" "); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("A blank channel must return ErrInvalidConfig, got '%v'", err)
+	}
+}
+
+func TestUpdateLeavePreviewChannel(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	// Only a preview build newer than the stable version is installed
+	previewPath := filepath.Join(installPath, "900")
+	err := os.Rename(filepath.Join(installPath, "003"), previewPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, version := range []string{"001", "002"} {
+		err = os.RemoveAll(filepath.Join(installPath, version))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	_, err = writeManifest(
+		previewPath,
+		VersionMap{Version: "900", Channel: ChannelPreview},
+		ManifestSourceUpdate,
+		nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	newVersion, _, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if newVersion.Version != "004" || newVersion.ReleaseChannel() != ChannelStable {
+		t.Errorf("Expected stable version 004, got %s on %s",
+			newVersion.Version,
+			newVersion.ReleaseChannel())
+	}
+	latestVersion, err := updater.GetLatestVersion()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if latestVersion.Version != "004" {
+		t.Errorf("Expected the stable version to be the latest, got '%s'", latestVersion.Version)
+	}
+}
+
 func TestGetOSDistribution(t *testing.T) {
 	osDistribution := updater.GetOSDistribution()
 	if osDistribution.Distribution == "" {
@@ -115,7 +333,7 @@ func TestUpdateVersionMap(t *testing.T) {
 	previousURL := updater.updateURL
 	updater.installPath = "/tmp"
 	updater.updateURL = "httx://localhost"
-	err := updater.updateVersionMap()
+	err := updater.updateVersionMap(context.Background())
 	if err == nil {
 		t.Error("Invalid version URL and local file must fail")
 	}
@@ -124,7 +342,7 @@ func TestUpdateVersionMap(t *testing.T) {
 }
 
 func TestGetFilelist(t *testing.T) {
-	list, err := updater.getFilelist("./test-resources")
+	list, err := updater.getFilelist(context.Background(), "./test-resources")
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -134,27 +352,196 @@ func TestGetFilelist(t *testing.T) {
 }
 
 func TestGenerateHashes(t *testing.T) {
-	list, err := updater.getFilelist("./test-resources/installs")
+	list, err := updater.getFilelist(context.Background(), "./test-resources/installs")
 	if err != nil {
 		t.Error(err.Error())
 	}
 	feedbackChan := make(chan HashProgressEvent)
-	go updater.GenerateHashes(list, 1, feedbackChan)
-	completed := 0
-	for feedback := range feedbackChan {
-		if feedback.Completed {
-			completed++
+	done := make(chan int)
+	go func() {
+		completed := 0
+		for feedback := range feedbackChan {
+			if feedback.Completed {
+				completed++
+			}
 		}
+		done <- completed
+	}()
+	hashes, err := updater.GenerateHashes(list, 1, feedbackChan)
+	close(feedbackChan)
+	if err != nil {
+		t.Error(err.Error())
 	}
-	if len(list) != completed {
+	if completed := <-done; len(list) != completed {
 		t.Error("Not all hashes were generated for the given list")
 	}
+	if len(hashes) != len(list) {
+		t.Errorf("Expected %d hashes, got %d", len(list), len(hashes))
+	}
+}
 
+func TestGenerateHashesEmpty(t *testing.T) {
+	hashes, err := updater.GenerateHashes(nil, 1, nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(hashes) != 0 {
+		t.Errorf("Expected no hashes, got %d", len(hashes))
+	}
+}
+
+func TestGenerateHashesErrors(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	list, err := updater.getFilelist(context.Background(), installPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	missingPath := filepath.Join(installPath, "missing.pak")
+	list = append(list, missingPath)
+
+	hashes, err := updater.GenerateHashes(list, 2, nil)
+	var hashErr *HashError
+	if !errors.As(err, &hashErr) {
+		t.Fatalf("Expected a HashError, got '%v'", err)
+	}
+	if len(hashErr.Files) != 1 || hashErr.Files[missingPath] == "" {
+		t.Errorf("Expected only '%s' to fail, got %v", missingPath, hashErr.Files)
+	}
+	if len(hashes) != len(list)-1 {
+		t.Errorf("Expected %d hashes, got %d", len(list)-1, len(hashes))
+	}
+}
+
+func TestGenerateHashesManyFiles(t *testing.T) {
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(installPath)
+	var list []string
+	for i := 0; i < 1500; i++ {
+		path := filepath.Join(installPath, fmt.Sprintf("%d.txt", i))
+		err = ioutil.WriteFile(path, []byte(fmt.Sprintf("%d", i)), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		list = append(list, path)
+	}
+	hashes, err := updater.GenerateHashes(list, 4, nil)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(hashes) != len(list) {
+		t.Errorf("Expected %d hashes, got %d", len(list), len(hashes))
+	}
+}
+
+func TestGenerateHashesBufferSizes(t *testing.T) {
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(installPath)
+	// Files that are a multiple of the copy buffer size must complete
+	var list []string
+	expected := make(map[string]string)
+	for _, size := range []int{0, 1, 32768, 65536, 100000} {
+		path := filepath.Join(installPath, fmt.Sprintf("%d.pak", size))
+		data := []byte(strings.Repeat("u", size))
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		list = append(list, path)
+		expected[path] = fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+	hashes, err := updater.GenerateHashes(list, 2, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for path, hash := range expected {
+		if hashes[path] != hash {
+			t.Errorf("Expected hash '%s' for %s, got '%s'", hash, path, hashes[path])
+		}
+	}
+}
+
+func TestGenerateHashesWorkersExit(t *testing.T) {
+	list, err := updater.getFilelist(context.Background(), "./test-resources/installs")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	updater.httpClient.CloseIdleConnections()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		_, err = updater.GenerateHashes(list, 8, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if left := goroutinesAbove(updater, before); left > 0 {
+		t.Errorf("Expected the hash workers to exit, %d goroutines left running",
+			left)
+	}
+}
+
+// goroutinesAbove returns the number of goroutines running above before.
+// The idle HTTP connections of the updater are closed first since their
+// goroutines come and go with keep-alive connections and aren't leaks
+func goroutinesAbove(updater *UT4Updater, before int) int {
+	updater.httpClient.CloseIdleConnections()
+	// Exited goroutines may take a moment to be accounted for
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine() - before
+}
+
+func TestHashJobWithoutContext(t *testing.T) {
+	progress := make(chan HashProgressEvent, 10)
+	HashJob{
+		filepath: "./test-resources/installs/003/UT4.txt",
+		progress: progress,
+	}.Process()
+	event := <-progress
+	for !event.Completed && event.Error == "" {
+		event = <-progress
+	}
+	if !event.Completed {
+		t.Errorf("Expected the file to be hashed, got '%s'", event.Error)
+	}
+}
+
+func TestGenerateHashesCancel(t *testing.T) {
+	list, err := updater.getFilelist(context.Background(), "./test-resources/installs")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hashes, err := updater.GenerateHashesContext(ctx, list, 1, nil)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got '%v'", err)
+	}
+	if len(hashes) != 0 {
+		t.Errorf("No files must be hashed after cancel, got %d", len(hashes))
+	}
+}
+
+func TestCheckForUpdateCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := updater.CheckForUpdateContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got '%v'", err)
+	}
 }
 
 func TestRemoteVersionHashes(t *testing.T) {
 
-	hashes, err := updater.getRemoteVersionHashes("latest")
+	hashes, err := updater.getRemoteVersionHashes(context.Background(), "latest")
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -188,14 +575,38 @@ func TestGenerateDeltaHash(t *testing.T) {
 	deltaOperations["d"] = "added"
 	deltaOperations["b"] = "modified"
 	deltaOperations["c"] = "removed"
+	nextHashes := map[string]string{
+		"a": "aaaa",
+		"b": "bbbb",
+		"d": "dddd",
+	}
 
-	hash := updater.generateDeltaHash(deltaOperations)
+	hash := updater.generateDeltaHash(deltaOperations, nextHashes)
 	if hash == "" {
 		t.Error("Hash may not be empty")
 	}
-	if hash != "deb3e700df1e6b29df98c26cc388417072b0bb5eeda3de7d035e186c315f161c" {
+	// sha256 of "ut4-delta-v1\nmodified\tbbbb\tb\nremoved\t\tc\nadded\tdddd\td\n"
+	if hash != "bc5e24c8971a8a4538418b26c0874720a52dfc7422fd04cd53227748962758f8" {
 		t.Error("Hash doesn't match input data")
 	}
+
+	// The same operations on other files must not collide
+	otherOperations := map[string]string{
+		"e": "added",
+		"f": "modified",
+		"g": "removed",
+	}
+	if updater.generateDeltaHash(otherOperations, nextHashes) == hash {
+		t.Error("Different files must generate a different hash")
+	}
+	// Neither may a different target version of the same files
+	otherHashes := map[string]string{
+		"b": "bbbb",
+		"d": "eeee",
+	}
+	if updater.generateDeltaHash(deltaOperations, otherHashes) == hash {
+		t.Error("Different target hashes must generate a different hash")
+	}
 }
 
 // TestGetUpdatePackage tests getting an update, creating the new version
@@ -203,11 +614,11 @@ func TestGenerateDeltaHash(t *testing.T) {
 func TestGetUpdatePackage(t *testing.T) {
 	// Get the update package URL
 	versionHash := "deb3e700df1e6b29df98c26cc388417072b0bb5eeda3de7d035e186c315f161c"
-	updateURL, err := updater.getUpdatePackageURL(versionHash)
+	updateCommand, err := updater.getUpdateCommand(context.Background(), versionHash)
 	if err != nil {
 		t.Error(err.Error())
 	}
-	if updateURL == "" {
+	if updateCommand.UpdateURL == "" {
 		t.Error("UpdateURL must not be blank")
 	}
 
@@ -219,12 +630,11 @@ func TestGetUpdatePackage(t *testing.T) {
 		t.Error(err.Error())
 	}
 	packageFile := filepath.Join(outputPath, "update-package.tar.gz")
-	cancelChan := make(chan bool)
 	feedbackChan := make(chan DownloadProgressEvent)
 	go updater.downloadUpdate(
-		updateURL,
+		context.Background(),
+		updateCommand,
 		packageFile,
-		cancelChan,
 		feedbackChan)
 	for feedback := range feedbackChan {
 		if feedback.Completed {
@@ -234,17 +644,712 @@ func TestGetUpdatePackage(t *testing.T) {
 
 	// Create the new version
 	version := "004"
-	newPath, err := updater.cloneLatestVersionTo(version, true)
+	newPath, err := updater.cloneLatestVersionTo(context.Background(), version, true, nil)
 	if err != nil {
 		t.Error(err.Error())
 	}
 	if newPath == "" {
 		t.Errorf("New path for version '%s' must not be blank", version)
 	}
+	defer os.RemoveAll(newPath)
 
 	// Apply the update
-	err = updater.applyUpdate(packageFile, newPath)
+	err = updater.applyUpdate(context.Background(), packageFile, newPath, nil, nil)
 	if err != nil {
 		t.Error(err.Error())
 	}
 }
+
+// useTestInstall points the updater to a copy of the test installs so
+// tests that add and remove versions don't modify the test resources.
+// The returned function restores the updater
+func useTestInstall(t *testing.T) (string, func()) {
+	installPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = CopyDir("./test-resources/installs", installPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	previousPath := updater.installPath
+	previousKeep := updater.keepVersions
+	previousRun := updater.runVersion
+	updater.installPath = installPath
+	return installPath, func() {
+		updater.installPath = previousPath
+		updater.keepVersions = previousKeep
+		updater.runVersion = previousRun
+		os.RemoveAll(installPath)
+	}
+}
+
+// runUpdate runs the update and collects all the feedback events
+func runUpdate(t *testing.T) (UT4Version, []UpdateProgressEvent, error) {
+	feedbackChan := make(chan []byte)
+	var events []UpdateProgressEvent
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for feedback := range feedbackChan {
+			var event UpdateProgressEvent
+			err := json.Unmarshal(feedback, &event)
+			if err != nil {
+				t.Error(err.Error())
+			}
+			events = append(events, event)
+		}
+	}()
+	newVersion, err := updater.Update(feedbackChan)
+	close(feedbackChan)
+	<-done
+	return newVersion, events, err
+}
+
+// TestUpdate tests the full update from the latest installed version
+func TestUpdate(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	newPath := filepath.Join(installPath, "004")
+
+	newVersion, events, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if newVersion.Version != "004" {
+		t.Errorf("Returned version '%s'. Expected '%s'", newVersion.Version, "004")
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(newPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "This is version 004" {
+		t.Errorf("Update was not applied, UT4.txt contains '%s'", contents)
+	}
+	fileInfo, err := os.Stat(filepath.Join(newPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := loadHashCache(newPath).lookup("UT4.txt", fileInfo); !ok {
+		t.Error("The hash cache of the new version must be seeded")
+	}
+	manifest := newVersion.Manifest
+	if manifest == nil {
+		t.Fatal("The new version must have a manifest")
+	}
+	if manifest.Version != "004" || manifest.Source != ManifestSourceUpdate {
+		t.Errorf("Unexpected manifest for the new version %+v", manifest)
+	}
+	if manifest.Files["UT4.txt"].Size != fileInfo.Size() {
+		t.Errorf("Expected UT4.txt of %d bytes in the manifest, got %d",
+			fileInfo.Size(),
+			manifest.Files["UT4.txt"].Size)
+	}
+	if len(events) == 0 {
+		t.Fatal("No feedback was received")
+	}
+	lastEvent := events[len(events)-1]
+	if lastEvent.Status != UpdateStatusCompleted || !lastEvent.Completed {
+		t.Errorf("Last event must be completed, got '%s'", lastEvent.Status)
+	}
+}
+
+func TestUpdateKeepsVersions(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	updater.keepVersions = 2
+	updater.runVersion = "0.0.1"
+
+	_, _, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// 004 and 003 are kept, 001 is pinned to run
+	for version, shouldExist := range map[string]bool{
+		"001": true, "002": false, "003": true, "004": true} {
+		_, err := os.Stat(filepath.Join(installPath, version))
+		if shouldExist && err != nil {
+			t.Errorf("Version '%s' must be kept", version)
+		}
+		if !shouldExist && err == nil {
+			t.Errorf("Version '%s' must be removed", version)
+		}
+	}
+}
+
+func TestUpdateInPlace(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	updater.keepVersions = 0
+
+	newVersion, _, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if newVersion.Path != filepath.Join(installPath, "004") {
+		t.Errorf("Returned path '%s' for the updated version", newVersion.Path)
+	}
+	versions, err := updater.GetVersionList()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(versions) != 1 || versions[0].Version != "004" {
+		t.Fatalf("Only version 004 must remain after updating in place, got %v",
+			versions)
+	}
+	if versions[0].Manifest == nil ||
+		versions[0].Manifest.Source != ManifestSourceInPlaceUpdate {
+		t.Errorf("Expected an in place update manifest, got %+v", versions[0].Manifest)
+	}
+}
+
+// writeTestPackage writes a tar.gz package with the given files. Setting
+// truncate writes the files shorter than their headers to simulate a
+// corrupt package
+func writeTestPackage(t *testing.T, packagePath string, files map[string]string, truncate bool) {
+	packageFile, err := os.Create(packagePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer packageFile.Close()
+	gzipWriter := gzip.NewWriter(packageFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, contents := range files {
+		size := int64(len(contents))
+		if truncate {
+			size += 100
+		}
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err = tarWriter.Write([]byte(contents))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if !truncate {
+		tarWriter.Close()
+	} else {
+		tarWriter.Flush()
+	}
+	gzipWriter.Close()
+}
+
+func TestApplyUpdateRollback(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+	packagePath := filepath.Join(installPath, "corrupt.tar.gz")
+	writeTestPackage(t, packagePath, map[string]string{
+		"UT4.txt": "This is version 004",
+	}, true)
+
+	err := updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+	if err == nil {
+		t.Fatal("Applying a corrupt package must fail")
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(versionPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "This is version 003" {
+		t.Errorf("Failed update must be rolled back, UT4.txt contains '%s'", contents)
+	}
+	stagingPath, backupPath := stagingPaths(versionPath)
+	for _, path := range []string{stagingPath, backupPath} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("'%s' must be removed after a failed update", path)
+		}
+	}
+}
+
+func TestApplyUpdateCancel(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+	packagePath := filepath.Join(installPath, "cancel.tar.gz")
+	writeTestPackage(t, packagePath, map[string]string{
+		"UT4.txt": "This is version 004",
+	}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := updater.applyUpdate(ctx, packagePath, versionPath, nil, nil)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got '%v'", err)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(versionPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) == "This is version 004" {
+		t.Error("A cancelled update must not be applied")
+	}
+	staging, backup := stagingPaths(versionPath)
+	for _, path := range []string{staging, backup} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("'%s' must be removed after a cancelled update", path)
+		}
+	}
+}
+
+func TestUpdateCancel(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := updater.UpdateContext(ctx, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got '%v'", err)
+	}
+	if _, err := os.Stat(filepath.Join(installPath, "004")); !os.IsNotExist(err) {
+		t.Error("A cancelled update must not install a new version")
+	}
+}
+
+func TestCloneVersionCancel(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	latestVersion, err := updater.GetLatestVersion()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = updater.cloneVersion(ctx, latestVersion, "004", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got '%v'", err)
+	}
+	if _, err := os.Stat(filepath.Join(installPath, "004")); !os.IsNotExist(err) {
+		t.Error("A cancelled clone must be removed")
+	}
+
+	// The walk stops at the next entry once cancelled
+	err = copyDir(ctx, latestVersion.Path, filepath.Join(installPath, "copy"), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from the copy, got '%v'", err)
+	}
+	_, err = updater.getFilelist(ctx, latestVersion.Path)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from the file list, got '%v'", err)
+	}
+}
+
+func TestRecoverStagedUpdate(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+	_, backupPath := stagingPaths(versionPath)
+
+	// Interrupted between moving the install and the staged update
+	err := os.Rename(versionPath, backupPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = updater.recoverStagedUpdates()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(versionPath, "UT4.txt")); err != nil {
+		t.Error("The previous install must be restored")
+	}
+}
+
+func TestApplyUpdateRemovesFiles(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+	staleDir := filepath.Join(versionPath, "Engine", "Stale")
+	err := os.MkdirAll(staleDir, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(staleDir, "stale.txt"), []byte("stale"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	packagePath := filepath.Join(installPath, "package.tar.gz")
+	writeTestPackage(t, packagePath, map[string]string{
+		"UT4.txt": "This is version 004",
+	}, false)
+
+	feedbackChan := make(chan ApplyProgressEvent, 10)
+	err = updater.applyUpdate(context.Background(), packagePath, versionPath, map[string]string{
+		"UT4.txt":                operationModified,
+		"Engine/Stale/stale.txt": operationRemoved,
+	}, feedbackChan)
+	close(feedbackChan)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(versionPath, "Engine")); err == nil {
+		t.Error("Removed files and empty directories must be deleted")
+	}
+	operations := make(map[string]string)
+	for event := range feedbackChan {
+		operations[event.Filepath] = event.Operation
+	}
+	if operations["Engine/Stale/stale.txt"] != operationRemoved {
+		t.Error("The removal must be reported")
+	}
+	if operations["UT4.txt"] != operationModified {
+		t.Error("The modification must be reported")
+	}
+}
+
+// writeTestPackageEntries writes a tar.gz package containing only the
+// given headers, regular files are written empty
+func writeTestPackageEntries(t *testing.T, packagePath string, headers []tar.Header) {
+	packageFile, err := os.Create(packagePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer packageFile.Close()
+	gzipWriter := gzip.NewWriter(packageFile)
+	defer gzipWriter.Close()
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+	for _, header := range headers {
+		header := header
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		err = tarWriter.WriteHeader(&header)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+}
+
+func TestApplyUpdateUnsafeEntries(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+	packagePath := filepath.Join(installPath, "unsafe.tar.gz")
+
+	unsafeEntries := []tar.Header{
+		{Name: "../evil.txt", Typeflag: tar.TypeReg},
+		{Name: "/tmp/evil.txt", Typeflag: tar.TypeReg},
+		{Name: "Engine/../../evil.txt", Typeflag: tar.TypeReg},
+		{Name: "..", Typeflag: tar.TypeDir},
+		{Name: "../", Typeflag: tar.TypeDir},
+		{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "../../etc"},
+		{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "evil", Typeflag: tar.TypeLink, Linkname: "../002/UT4.txt"},
+		{Name: "evil", Typeflag: tar.TypeFifo},
+	}
+	for _, entry := range unsafeEntries {
+		writeTestPackageEntries(t, packagePath, []tar.Header{entry})
+		err := updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+		if _, ok := err.(*UnsafeEntryError); !ok {
+			t.Errorf("Entry '%s' -> '%s' must be rejected, got '%v'",
+				entry.Name,
+				entry.Linkname,
+				err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(installPath, "evil.txt")); err == nil {
+		t.Error("Unsafe entries must not be written")
+	}
+
+	// Files can't be written through a link that leaves the install path
+	err := os.Symlink(installPath, filepath.Join(versionPath, "outside"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	writeTestPackageEntries(t, packagePath, []tar.Header{
+		{Name: "outside/evil.txt", Typeflag: tar.TypeReg},
+	})
+	err = updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+	expected := &UnsafeEntryError{
+		Name:   "outside/evil.txt",
+		Reason: "resolves outside the install path",
+	}
+	if unsafeErr, ok := err.(*UnsafeEntryError); !ok || *unsafeErr != *expected {
+		t.Errorf("Writing through a link outside the install must be rejected with '%v', got '%v'",
+			expected,
+			err)
+	}
+	os.Remove(filepath.Join(versionPath, "outside"))
+
+	// Links can't be chained to leave the install path
+	writeTestPackageEntries(t, packagePath, []tar.Header{
+		{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "d/l/l2", Typeflag: tar.TypeSymlink, Linkname: ".."},
+	})
+	err = updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+	if _, ok := err.(*UnsafeEntryError); !ok {
+		t.Errorf("Chained links outside the install must be rejected, got '%v'", err)
+	}
+	if _, err := os.Lstat(filepath.Join(versionPath, "l2")); err == nil {
+		t.Error("Chained links must not be created")
+	}
+
+	// Links inside the install path are allowed
+	writeTestPackageEntries(t, packagePath, []tar.Header{
+		{Name: "Engine/Binaries/UE4", Typeflag: tar.TypeSymlink, Linkname: "../../UT4.txt"},
+		{Name: "UT4-copy.txt", Typeflag: tar.TypeLink, Linkname: "UT4.txt"},
+	})
+	err = updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(versionPath, "Engine", "Binaries", "UE4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "This is version 003" {
+		t.Errorf("Symbolic link must point to UT4.txt, got '%s'", contents)
+	}
+}
+
+func TestApplyUpdateRootEntry(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	versionPath := filepath.Join(installPath, "003")
+
+	// Packages created with 'tar -C dir .' prefix every entry with './'
+	// and start with the './' root directory
+	err := updater.applyUpdate(context.Background(),
+		"./test-resources/packages/package-dot.tar.gz", versionPath, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(versionPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "This is version 004" {
+		t.Errorf("Expected UT4.txt to be updated, got '%s'", contents)
+	}
+	fileInfo, err := os.Stat(filepath.Join(versionPath, "Binaries", "UT4.sh"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if fileInfo.Mode().Perm() != 0755 {
+		t.Errorf("Expected Binaries/UT4.sh to have mode 0755, got %v",
+			fileInfo.Mode().Perm())
+	}
+
+	// Only the root directory may clean to the install path
+	packagePath := filepath.Join(installPath, "root.tar.gz")
+	writeTestPackageEntries(t, packagePath, []tar.Header{
+		{Name: ".", Typeflag: tar.TypeReg},
+	})
+	err = updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+	if _, ok := err.(*UnsafeEntryError); !ok {
+		t.Errorf("A file entry for the root must be rejected, got '%v'", err)
+	}
+}
+
+func TestDownloadUpdateChecksumMismatch(t *testing.T) {
+	updateCommand, err := updater.getUpdateCommand(context.Background(), "checksum")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	updateCommand.SHA256 = strings.Repeat("0", 64)
+
+	outputPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(outputPath)
+	packageFile := filepath.Join(outputPath, "update-package.tar.gz")
+	feedbackChan := make(chan DownloadProgressEvent, 10)
+	_, err = updater.downloadUpdate(
+		context.Background(),
+		updateCommand,
+		packageFile,
+		feedbackChan)
+	if err != ErrChecksumMismatch {
+		t.Errorf("Expected ErrChecksumMismatch, got '%v'", err)
+	}
+	if _, err := os.Stat(packageFile); err == nil {
+		t.Error("A package that doesn't match must be removed")
+	}
+}
+
+// newStalledTestServer returns a server that sends the headers of a package
+// download and then stops sending data until it is closed
+func newStalledTestServer() (*httptest.Server, chan struct{}) {
+	stop := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	return testServer, stop
+}
+
+func TestDownloadUpdateCancel(t *testing.T) {
+	testServer, stop := newStalledTestServer()
+	defer testServer.Close()
+	defer close(stop)
+	outputPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(outputPath)
+
+	updateCommand := UpdateCommand{
+		UpdateURL: testServer.URL + "/package.tar.gz",
+		Size:      1024,
+		SHA256:    strings.Repeat("0", 64),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = updater.downloadUpdate(ctx, updateCommand,
+		filepath.Join(outputPath, "package.tar.gz"), nil)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got '%v'", err)
+	}
+
+	previousIdleTimeout := updater.downloadIdleTimeout
+	updater.downloadIdleTimeout = time.Millisecond
+	defer func() { updater.downloadIdleTimeout = previousIdleTimeout }()
+	_, err = updater.downloadUpdate(context.Background(), updateCommand,
+		filepath.Join(outputPath, "package.tar.gz"), nil)
+	if err != ErrDownloadTimeout {
+		t.Errorf("Expected ErrDownloadTimeout, got '%v'", err)
+	}
+}
+
+func TestDownloadUpdateHeaderTimeout(t *testing.T) {
+	// The server never sends the response headers
+	stop := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer testServer.Close()
+	defer close(stop)
+	outputPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(outputPath)
+
+	updateCommand := UpdateCommand{
+		UpdateURL: testServer.URL + "/package.tar.gz",
+		Size:      1024,
+		SHA256:    strings.Repeat("0", 64),
+	}
+	previousTimeout := updater.downloadTimeout
+	previousIdleTimeout := updater.downloadIdleTimeout
+	defer func() {
+		updater.downloadTimeout = previousTimeout
+		updater.downloadIdleTimeout = previousIdleTimeout
+	}()
+	timeouts := []struct {
+		name        string
+		timeout     time.Duration
+		idleTimeout time.Duration
+	}{
+		{name: "download", timeout: 200 * time.Millisecond},
+		{name: "idle", idleTimeout: 200 * time.Millisecond},
+	}
+	for _, timeout := range timeouts {
+		updater.downloadTimeout = timeout.timeout
+		updater.downloadIdleTimeout = timeout.idleTimeout
+		result := make(chan error, 1)
+		go func() {
+			_, err := updater.downloadUpdate(context.Background(), updateCommand,
+				filepath.Join(outputPath, "package.tar.gz"), nil)
+			result <- err
+		}()
+		select {
+		case err = <-result:
+			if err != ErrDownloadTimeout {
+				t.Errorf("%s: Expected ErrDownloadTimeout, got '%v'", timeout.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: The download must time out while waiting for the headers",
+				timeout.name)
+		}
+	}
+}
+
+func TestDownloadUpdateConnectionError(t *testing.T) {
+	updateCommand := UpdateCommand{
+		UpdateURL: "http://127.0.0.1:1/package.tar.gz",
+		Size:      1024,
+		SHA256:    strings.Repeat("0", 64),
+	}
+	_, err := updater.downloadUpdate(context.Background(), updateCommand,
+		filepath.Join(os.TempDir(), "ut4updater-package.tar.gz"), nil)
+	if !errors.Is(err, ErrServerUnavailable) {
+		t.Errorf("Expected ErrServerUnavailable, got '%v'", err)
+	}
+}
+
+func TestDownloadUpdateResume(t *testing.T) {
+	updateCommand, err := updater.getUpdateCommand(context.Background(), "resume")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	packageBytes, err := ioutil.ReadFile("./test-resources/packages/package.tar.gz")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	outputPath, err := ioutil.TempDir("", "ut4updater")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(outputPath)
+	packageFile := filepath.Join(outputPath, "package.tar.gz")
+
+	// Write half the package to simulate an interrupted download
+	err = ioutil.WriteFile(packageFile, packageBytes[:len(packageBytes)/2], 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for len(packageRangeRequests) > 0 {
+		<-packageRangeRequests
+	}
+	_, err = updater.downloadUpdate(context.Background(), updateCommand, packageFile, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedRange := fmt.Sprintf("bytes=%d-", len(packageBytes)/2)
+	if rangeHeader := <-packageRangeRequests; rangeHeader != expectedRange {
+		t.Errorf("Download must resume with '%s', got '%s'", expectedRange, rangeHeader)
+	}
+
+	// A complete download is validated and reused
+	_, err = updater.downloadUpdate(context.Background(), updateCommand, packageFile, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(packageRangeRequests) != 0 {
+		t.Error("A complete download must not be downloaded again")
+	}
+
+	// A corrupt download is downloaded again
+	corruptBytes := make([]byte, len(packageBytes))
+	err = ioutil.WriteFile(packageFile, corruptBytes, 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = updater.downloadUpdate(context.Background(), updateCommand, packageFile, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if rangeHeader := <-packageRangeRequests; rangeHeader != "" {
+		t.Errorf("A corrupt download must start over, got range '%s'", rangeHeader)
+	}
+}
@@ -4,11 +4,16 @@ package ut4updater
 type UT4Version struct {
 	VersionMap
 	Path string
+	// Manifest is nil for versions without a manifest
+	Manifest *Manifest
 }
 
-// ByVersion allows for sorting by the build version number
+// ByVersion sorts the latest version first by the numeric build version,
+// versions with the same build are sorted by their semantic version
 type ByVersion []UT4Version
 
-func (a ByVersion) Len() int           { return len(a) }
-func (a ByVersion) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByVersion) Less(i, j int) bool { return a[i].Version > a[j].Version }
+func (a ByVersion) Len() int      { return len(a) }
+func (a ByVersion) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByVersion) Less(i, j int) bool {
+	return compareVersionMaps(a[i].VersionMap, a[j].VersionMap) > 0
+}
@@ -0,0 +1,257 @@
+package ut4updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// VerifyResult lists the differences between an installed version and the
+// hashes of the version on the update server. Paths are slash separated
+// and relative to the version directory
+type VerifyResult struct {
+	Version  string
+	Missing  []string
+	Modified []string
+	// Extra files are not part of the version, they don't make the
+	// installation invalid and are not removed by Repair
+	Extra []string
+}
+
+// IsValid returns true if no files are missing or modified
+func (result VerifyResult) IsValid() bool {
+	return len(result.Missing) == 0 && len(result.Modified) == 0
+}
+
+// Verify hashes every file of the installed version and compares it to the
+// hashes of the version on the update server. Progress is sent to feedback
+// as JSON encoded UpdateProgressEvents if it isn't nil
+func (updater *UT4Updater) Verify(
+	version string,
+	feedback chan []byte) (VerifyResult, error) {
+	return updater.VerifyContext(context.Background(), version, feedback)
+}
+
+// VerifyContext is Verify with a context
+func (updater *UT4Updater) VerifyContext(
+	ctx context.Context,
+	version string,
+	feedback chan []byte) (VerifyResult, error) {
+	installedVersion, err := updater.getInstalledVersion(ctx, version)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	// The hash cache can't detect changes that kept the size and
+	// modification time, so everything is hashed
+	result, _, err := updater.verifyInstall(ctx, installedVersion, true, feedback)
+	return result, err
+}
+
+// Repair verifies the installed version and restores its missing and
+// modified files from an update package with only those files
+func (updater *UT4Updater) Repair(
+	version string,
+	feedback chan []byte) (VerifyResult, error) {
+	return updater.RepairContext(context.Background(), version, feedback)
+}
+
+// RepairContext is Repair with a context
+func (updater *UT4Updater) RepairContext(
+	ctx context.Context,
+	version string,
+	feedback chan []byte) (VerifyResult, error) {
+	installedVersion, err := updater.getInstalledVersion(ctx, version)
+	if err != nil {
+		return VerifyResult{}, updater.failUpdate(feedback, version, err)
+	}
+	result, remoteHashes, err := updater.verifyInstall(
+		ctx,
+		installedVersion,
+		true,
+		feedback)
+	if err != nil {
+		return result, updater.failUpdate(feedback, version, err)
+	}
+	if result.IsValid() {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:    UpdateStatusCompleted,
+			Version:   version,
+			Message:   fmt.Sprintf("Version %s is valid", version),
+			Percent:   100.00,
+			Completed: true,
+		})
+		return result, nil
+	}
+
+	// Broken files are restored the same way an update adds and
+	// modifies files
+	deltaOperations := make(map[string]string)
+	for _, file := range result.Missing {
+		deltaOperations[file] = operationAdded
+	}
+	for _, file := range result.Modified {
+		deltaOperations[file] = operationModified
+	}
+	deltaHash := updater.generateDeltaHash(deltaOperations, remoteHashes)
+	updateCommand, err := updater.getUpdateCommand(ctx, deltaHash)
+	// Without a package the files are downloaded one by one
+	fileDownload := errors.Is(err, ErrNotFound) && updater.fileDownloads
+	if err != nil && !fileDownload {
+		return result, updater.failUpdate(feedback, version, err)
+	}
+	packagePath := updater.getFileCachePath(deltaHash)
+	var modes map[string]os.FileMode
+	if fileDownload {
+		// Missing files can only be restored with the permissions from
+		// the update server
+		modes, err = updater.getRemoteVersionModes(ctx, installedVersion.Version)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return result, updater.failUpdate(feedback, version, err)
+		}
+		if !fileModesKnown(deltaOperations, modes) {
+			return result, updater.failUpdate(feedback, version, newError(
+				ErrNotFound,
+				fmt.Errorf("No package and no file modes to restore %d missing files",
+					len(result.Missing))))
+		}
+		err = updater.downloadFiles(
+			ctx,
+			packagePath,
+			deltaOperations,
+			remoteHashes,
+			version,
+			feedback)
+	} else {
+		packagePath, err = updater.getPackageCachePath(updateCommand)
+		if err != nil {
+			return result, updater.failUpdate(feedback, version, err)
+		}
+		err = updater.downloadPackage(
+			ctx,
+			updateCommand,
+			packagePath,
+			version,
+			feedback)
+	}
+	if err != nil {
+		return result, updater.failUpdate(feedback, version, err)
+	}
+
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:  UpdateStatusApplying,
+		Version: version,
+		Message: fmt.Sprintf("Restoring %d files", len(deltaOperations)),
+	})
+	if fileDownload {
+		err = updater.applyFiles(
+			ctx,
+			packagePath,
+			installedVersion.Path,
+			deltaOperations,
+			remoteHashes,
+			modes,
+			version,
+			feedback)
+	} else {
+		err = updater.applyPackage(
+			ctx,
+			packagePath,
+			installedVersion.Path,
+			deltaOperations,
+			version,
+			feedback)
+	}
+	if err != nil {
+		return result, updater.failUpdate(feedback, version, err)
+	}
+	// The package is kept until it's applied so a failed repair
+	// doesn't download it again
+	_ = os.RemoveAll(packagePath)
+
+	// The files that weren't restored were hashed above and are
+	// taken from the hash cache
+	result, _, err = updater.verifyInstall(ctx, installedVersion, false, feedback)
+	if err != nil {
+		return result, updater.failUpdate(feedback, version, err)
+	}
+	if !result.IsValid() {
+		return result, updater.failUpdate(feedback, version, newError(
+			ErrRepairFailed,
+			fmt.Errorf("%d files are still missing or modified",
+				len(result.Missing)+len(result.Modified))))
+	}
+
+	source := ManifestSourceLocal
+	if installedVersion.Manifest != nil {
+		source = installedVersion.Manifest.Source
+	}
+	_, err = writeManifest(
+		installedVersion.Path,
+		installedVersion.VersionMap,
+		source,
+		remoteHashes)
+	if err != nil {
+		updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+			Status:  UpdateStatusApplying,
+			Version: version,
+			Error:   err.Error(),
+		})
+	}
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:    UpdateStatusCompleted,
+		Version:   version,
+		Message:   fmt.Sprintf("Repaired version %s", version),
+		Percent:   100.00,
+		Completed: true,
+	})
+	return result, nil
+}
+
+// verifyInstall compares the hashes of the installed version to the hashes
+// on the update server, the remote hashes are returned with the result
+func (updater *UT4Updater) verifyInstall(
+	ctx context.Context,
+	installedVersion UT4Version,
+	force bool,
+	feedback chan []byte) (VerifyResult, map[string]string, error) {
+
+	result := VerifyResult{Version: installedVersion.Version}
+	remoteHashes, err := updater.getRemoteVersionHashes(
+		ctx,
+		installedVersion.Version)
+	if err != nil {
+		return result, nil, err
+	}
+	localHashes, err := updater.hashInstall(
+		ctx,
+		installedVersion.Path,
+		force,
+		installedVersion.Version,
+		feedback)
+	if err != nil {
+		return result, nil, err
+	}
+	updater.sendUpdateFeedback(feedback, UpdateProgressEvent{
+		Status:  UpdateStatusVerifying,
+		Version: installedVersion.Version,
+		Message: fmt.Sprintf("Verifying %d files", len(remoteHashes)),
+	})
+
+	operations := updater.calculateHashDeltaOperations(localHashes, remoteHashes)
+	for file, operation := range operations {
+		switch operation {
+		case operationAdded:
+			result.Missing = append(result.Missing, file)
+		case operationModified:
+			result.Modified = append(result.Modified, file)
+		case operationRemoved:
+			result.Extra = append(result.Extra, file)
+		}
+	}
+	sort.Strings(result.Missing)
+	sort.Strings(result.Modified)
+	sort.Strings(result.Extra)
+	return result, remoteHashes, nil
+}
@@ -0,0 +1,153 @@
+package ut4updater
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyAndRepair(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	_, _, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	versionPath := filepath.Join(installPath, "004")
+
+	result, err := updater.Verify("004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !result.IsValid() || len(result.Extra) != 0 {
+		t.Errorf("A freshly updated version must be valid, got %+v", result)
+	}
+
+	// The modified file keeps its size and modification time so only a
+	// full rehash can find it
+	fileInfo, err := os.Stat(filepath.Join(versionPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(
+		filepath.Join(versionPath, "UT4.txt"),
+		[]byte("This is version 00X"),
+		0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = os.Chtimes(
+		filepath.Join(versionPath, "UT4.txt"),
+		fileInfo.ModTime(),
+		fileInfo.ModTime())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(versionPath, "extra.txt"), []byte("extra"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err = updater.Verify("004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if result.IsValid() {
+		t.Error("The modified version must not be valid")
+	}
+	if !reflect.DeepEqual(result.Modified, []string{"UT4.txt"}) ||
+		!reflect.DeepEqual(result.Extra, []string{"extra.txt"}) ||
+		len(result.Missing) != 0 {
+		t.Errorf("Unexpected verify result %+v", result)
+	}
+
+	result, err = updater.Repair("004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !result.IsValid() {
+		t.Errorf("The repaired version must be valid, got %+v", result)
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(versionPath, "UT4.txt"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(contents) != "This is version 004" {
+		t.Errorf("UT4.txt was not restored, it contains '%s'", contents)
+	}
+	if _, err := os.Stat(filepath.Join(versionPath, "extra.txt")); err != nil {
+		t.Error("Extra files must not be removed by a repair")
+	}
+}
+
+func TestVerifySymlinks(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	_, _, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	versionPath := filepath.Join(installPath, "004")
+
+	// Links extracted from a package aren't part of the hashes
+	packagePath := filepath.Join(installPath, "links.tar.gz")
+	writeTestPackageEntries(t, packagePath, []tar.Header{
+		{Name: "Linked", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "UT4-link.txt", Typeflag: tar.TypeSymlink, Linkname: "UT4.txt"},
+	})
+	err = updater.applyUpdate(context.Background(), packagePath, versionPath, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	hashes, err := updater.hashInstall(context.Background(), versionPath, true, "004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := hashes["Linked"]; ok {
+		t.Error("The directory link must not be hashed")
+	}
+	if _, ok := hashes["UT4-link.txt"]; ok {
+		t.Error("The file link must not be hashed")
+	}
+
+	result, err := updater.Verify("004", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !result.IsValid() || len(result.Extra) != 0 {
+		t.Errorf("Links must not change the verify result, got %+v", result)
+	}
+}
+
+func TestRepairFailed(t *testing.T) {
+	installPath, restore := useTestInstall(t)
+	defer restore()
+	_, _, err := runUpdate(t)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// The test package only contains UT4.txt
+	err = os.Remove(filepath.Join(installPath, "004", ".gitkeep"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err := updater.Repair("004", nil)
+	if !errors.Is(err, ErrRepairFailed) {
+		t.Errorf("Expected ErrRepairFailed, got '%v'", err)
+	}
+	if !reflect.DeepEqual(result.Missing, []string{".gitkeep"}) {
+		t.Errorf("Expected .gitkeep to be missing, got %+v", result)
+	}
+}
+
+func TestVerifyNotInstalled(t *testing.T) {
+	_, err := updater.Verify("999", nil)
+	if !errors.Is(err, ErrVersionNotInstalled) {
+		t.Errorf("Expected ErrVersionNotInstalled, got '%v'", err)
+	}
+}
@@ -1,9 +1,20 @@
 package ut4updater
 
 import (
+	"sort"
+	"strings"
 	"time"
 )
 
+// The release channels of the update server
+const (
+	// ChannelStable is the channel of regular releases, versions without
+	// a channel are stable
+	ChannelStable = "stable"
+	// ChannelPreview is the channel of preview releases
+	ChannelPreview = "preview"
+)
+
 // VersionMaps is an declaration for operations on a list of version maps
 type VersionMaps []VersionMap
 
@@ -13,6 +24,44 @@ type VersionMap struct {
 	Version     string    `json:"version"`
 	SemVer      string    `json:"semver"`
 	ReleaseDate time.Time `json:"released"`
+	// Channel is the release channel, see ReleaseChannel
+	Channel      string `json:"channel,omitempty"`
+	Changelog    string `json:"changelog,omitempty"`
+	ChangelogURL string `json:"changelog_url,omitempty"`
+	// PackageSize is the size of the full version in bytes
+	PackageSize int64 `json:"package_size,omitempty"`
+	// MinUpdaterVersion is the oldest UpdaterVersion that can
+	// install the version
+	MinUpdaterVersion string `json:"min_updater_version,omitempty"`
+	// Yanked versions have been withdrawn and must not be installed
+	Yanked bool `json:"yanked,omitempty"`
+}
+
+// ReleaseChannel returns the release channel of the version,
+// ChannelStable if none is set
+func (versionMap VersionMap) ReleaseChannel() string {
+	if versionMap.Channel == "" {
+		return ChannelStable
+	}
+	return versionMap.Channel
+}
+
+// RequiresNewerUpdater returns true if the version can't be installed by
+// this updater because it needs a newer UpdaterVersion
+func (versionMap VersionMap) RequiresNewerUpdater() bool {
+	return versionMap.MinUpdaterVersion != "" &&
+		compareSemVer(UpdaterVersion, versionMap.MinUpdaterVersion) < 0
+}
+
+// FilterByChannel returns the versions in the release channel
+func (versionMaps VersionMaps) FilterByChannel(channel string) VersionMaps {
+	var filtered VersionMaps
+	for _, versionMap := range versionMaps {
+		if versionMap.ReleaseChannel() == channel {
+			filtered = append(filtered, versionMap)
+		}
+	}
+	return filtered
 }
 
 // GetVersionMapByVersionNumber retrieves the version map information
@@ -26,3 +75,75 @@ func (versionMaps VersionMaps) GetVersionMapByVersionNumber(
 	}
 	return VersionMap{}
 }
+
+// GetVersionMapBySemVer retrieves the version map information based on
+// the semantic version, build metadata and a leading 'v' are ignored
+func (versionMaps VersionMaps) GetVersionMapBySemVer(
+	semVer string) VersionMap {
+	if _, ok := parseSemVer(semVer); !ok {
+		return VersionMap{}
+	}
+	for _, versionMap := range versionMaps {
+		if compareSemVer(versionMap.SemVer, semVer) == 0 {
+			return versionMap
+		}
+	}
+	return VersionMap{}
+}
+
+// GetLatestVersionMap returns the latest version in the release channel
+// that can be installed, versions that are yanked or require a newer
+// updater are skipped. False is returned if there is none
+func (versionMaps VersionMaps) GetLatestVersionMap(
+	channel string) (VersionMap, bool) {
+	var latest VersionMap
+	found := false
+	for _, versionMap := range versionMaps {
+		if versionMap.Yanked ||
+			versionMap.RequiresNewerUpdater() ||
+			versionMap.ReleaseChannel() != channel {
+			continue
+		}
+		if !found || compareVersionMaps(versionMap, latest) > 0 {
+			latest = versionMap
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// GetVersionMapsBetween returns the versions newer than the build version
+// from up to and including the build version to, oldest first. This is
+// used to show the changelogs of an update
+func (versionMaps VersionMaps) GetVersionMapsBetween(
+	from string,
+	to string) VersionMaps {
+	fromMap := versionMaps.GetVersionMapByVersionNumber(from)
+	fromMap.Version = from
+	toMap := versionMaps.GetVersionMapByVersionNumber(to)
+	toMap.Version = to
+
+	var between VersionMaps
+	for _, versionMap := range versionMaps {
+		if compareVersionMaps(versionMap, fromMap) > 0 &&
+			compareVersionMaps(versionMap, toMap) <= 0 {
+			between = append(between, versionMap)
+		}
+	}
+	sort.Slice(between, func(i, j int) bool {
+		return compareVersionMaps(between[i], between[j]) < 0
+	})
+	return between
+}
+
+// compareVersionMaps returns -1, 0 or 1 if version a is older, the same or
+// newer than b by the numeric build version and then the semantic version
+func compareVersionMaps(a VersionMap, b VersionMap) int {
+	if result := compareBuild(a.Version, b.Version); result != 0 {
+		return result
+	}
+	if result := compareSemVer(a.SemVer, b.SemVer); result != 0 {
+		return result
+	}
+	return strings.Compare(a.Version, b.Version)
+}
@@ -0,0 +1,112 @@
+package ut4updater
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var testVersionMaps = VersionMaps{
+	{Version: "3525360", SemVer: "0.1.12", Changelog: "Stable 12"},
+	{Version: "3395761", SemVer: "0.1.11", Changelog: "Stable 11"},
+	{Version: "3600000", SemVer: "0.1.13-preview.1", Channel: ChannelPreview},
+	{Version: "3550000", SemVer: "0.1.13", Yanked: true},
+	{Version: "3700000", SemVer: "0.2.0", MinUpdaterVersion: "99.0.0"},
+}
+
+func TestVersionMapJSON(t *testing.T) {
+	data := []byte(`{"version":"3600000","semver":"0.1.13-preview.1",` +
+		`"released":"2017-07-25T00:00:00Z","channel":"preview",` +
+		`"changelog":"New maps","changelog_url":"https://example.com/changelog",` +
+		`"package_size":1024,"min_updater_version":"1.0.0","yanked":true}`)
+	var versionMap VersionMap
+	err := json.Unmarshal(data, &versionMap)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if versionMap.ReleaseChannel() != ChannelPreview ||
+		versionMap.Changelog != "New maps" ||
+		versionMap.ChangelogURL != "https://example.com/changelog" ||
+		versionMap.PackageSize != 1024 ||
+		versionMap.MinUpdaterVersion != "1.0.0" ||
+		!versionMap.Yanked {
+		t.Errorf("Version map fields were not decoded %+v", versionMap)
+	}
+	if (VersionMap{}).ReleaseChannel() != ChannelStable {
+		t.Error("Versions without a channel must be stable")
+	}
+}
+
+func TestGetLatestVersionMap(t *testing.T) {
+	latest, ok := testVersionMaps.GetLatestVersionMap(ChannelStable)
+	// 3550000 is yanked and 3700000 requires a newer updater
+	if !ok || latest.Version != "3525360" {
+		t.Errorf("Expected stable version 3525360, got '%s'", latest.Version)
+	}
+	latest, ok = testVersionMaps.GetLatestVersionMap(ChannelPreview)
+	if !ok || latest.Version != "3600000" {
+		t.Errorf("Expected preview version 3600000, got '%s'", latest.Version)
+	}
+	_, ok = testVersionMaps.GetLatestVersionMap("beta")
+	if ok {
+		t.Error("An unknown channel must not have a latest version")
+	}
+}
+
+func TestGetVersionMaps(t *testing.T) {
+	versionMaps := updater.GetVersionMaps()
+	if len(versionMaps) == 0 {
+		t.Fatal("Expected the version maps from the update server")
+	}
+	if versionMaps.GetVersionMapByVersionNumber(versionMaps[0].Version).Version == "" {
+		t.Errorf("Expected version %s to be found", versionMaps[0].Version)
+	}
+	// The returned maps are a copy
+	versionMaps[0].Version = "changed"
+	if updater.GetVersionMaps()[0].Version == "changed" {
+		t.Error("Changing the returned version maps must not change the updater")
+	}
+}
+
+func TestGetVersionMapBySemVer(t *testing.T) {
+	versionMap := testVersionMaps.GetVersionMapBySemVer("v0.1.12")
+	if versionMap.Version != "3525360" {
+		t.Errorf("Expected version 3525360, got '%s'", versionMap.Version)
+	}
+	versionMap = testVersionMaps.GetVersionMapBySemVer("0.1.14")
+	if versionMap.Version != "" {
+		t.Errorf("Expected no version, got '%s'", versionMap.Version)
+	}
+}
+
+func TestGetVersionMapsBetween(t *testing.T) {
+	between := testVersionMaps.GetVersionMapsBetween("3395761", "3600000")
+	expected := []string{"3525360", "3550000", "3600000"}
+	if len(between) != len(expected) {
+		t.Fatalf("Expected %d versions, got %v", len(expected), between)
+	}
+	for i, versionMap := range between {
+		if versionMap.Version != expected[i] {
+			t.Errorf("Expected version %s at %d, got %s", expected[i], i, versionMap.Version)
+		}
+	}
+}
+
+func TestCheckInstallable(t *testing.T) {
+	previousVersionMaps := updater.versionMaps
+	defer func() { updater.versionMaps = previousVersionMaps }()
+	updater.versionMaps = testVersionMaps
+
+	err := updater.checkInstallable("3550000")
+	if !errors.Is(err, ErrVersionYanked) {
+		t.Errorf("Expected ErrVersionYanked, got '%v'", err)
+	}
+	err = updater.checkInstallable("3700000")
+	if !errors.Is(err, ErrUpdaterOutdated) {
+		t.Errorf("Expected ErrUpdaterOutdated, got '%v'", err)
+	}
+	err = updater.checkInstallable("3525360")
+	if err != nil {
+		t.Errorf("Expected no error, got '%v'", err)
+	}
+}